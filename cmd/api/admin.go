@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type updateRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user organizer admin"`
+}
+
+// updateUserRole changes the role of a user
+//
+// @Summary Changes the role of a user
+// @Description Sets the role of a user: users and organizers create and run their own events, and admins also moderate events and manage users. Requires the users:manage permission.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param request body updateRoleRequest true "New role"
+// @Success 200 {object} database.User
+// @Security BearerAuth
+// @Router /api/v1/admin/users/{id}/role [put]
+func (app *application) updateUserRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	var req updateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := app.models.Users.UpdateRole(user.Id, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	user.Role = req.Role
+	c.JSON(http.StatusOK, user)
+}
+
+// unlockUser lifts a login lockout
+//
+// @Summary Unlocks a user account
+// @Description Clears the failed login counter and any lockout of a user's email address. Requires the users:manage permission.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 204
+// @Security BearerAuth
+// @Router /api/v1/admin/users/{id}/unlock [post]
+func (app *application) unlockUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	key := loginEmailKey(user.Email)
+	if err := app.loginAttempts.Reset(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	app.audit(c, app.getUserFromContext(c), "auth.unlock", key, "")
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// restoreUser cancels the deletion of an account
+//
+// @Summary Restores a deleted user account
+// @Description Cancels the scheduled deletion of an account, which brings back the account and its events, as logging in does. Where the seats of the account were given to the waitlist meanwhile, it goes back on the waitlist. Requires the users:manage permission.
+// @Tags Admin
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} database.User
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/admin/users/{id}/restore [post]
+func (app *application) restoreUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.DeletionScheduledAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is not deleted"})
+		return
+	}
+
+	if err := app.models.Users.Restore(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore user"})
+		return
+	}
+	user.DeletionScheduledAt = nil
+
+	app.audit(c, app.getUserFromContext(c), "account.deletion_cancelled", "user:"+strconv.Itoa(user.Id), "")
+
+	c.JSON(http.StatusOK, user)
+}
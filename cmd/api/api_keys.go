@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyPrefix marks our keys so secret scanners and humans can recognise
+// them.
+const apiKeyPrefix = "evk_"
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type createAPIKeyResponse struct {
+	*database.APIKey
+	Key string `json:"key"`
+}
+
+// newAPIKey returns a fresh key and the visible prefix stored next to its
+// hash. The key has the form evk_<prefix>_<secret>.
+func newAPIKey() (key string, prefix string, err error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(b)
+
+	secret, err := generateToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	return prefix + "_" + secret, prefix, nil
+}
+
+// createAPIKey creates a personal API key
+//
+// @Summary Creates an API key
+// @Description Creates an API key for the current user. Scopes have to be permissions of the user's role, such as events:read or events:write. The key itself is only returned once.
+// @Tags API Keys
+// @Accept json
+// @Produce json
+// @Param request body createAPIKeyRequest true "Key name, scopes and optional expiry"
+// @Success 201 {object} createAPIKeyResponse
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me/api-keys [post]
+func (app *application) createAPIKey(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	for _, scope := range req.Scopes {
+		if !hasPermission(user, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope " + strconv.Quote(scope)})
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt must be in the future"})
+			return
+		}
+		expiresAt := req.ExpiresAt.UTC()
+		req.ExpiresAt = &expiresAt
+	}
+
+	rawKey, prefix, err := newAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	key := &database.APIKey{
+		UserId:    user.Id,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(rawKey),
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := app.models.APIKeys.Insert(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	app.audit(c, user, "apikey.create", "apikey:"+strconv.Itoa(key.Id), prefix)
+
+	c.JSON(http.StatusCreated, createAPIKeyResponse{APIKey: key, Key: rawKey})
+}
+
+// getAPIKeys lists the API keys of the current user
+//
+// @Summary Lists API keys
+// @Description Returns the active API keys of the current user. The keys themselves are never returned again, only their prefixes.
+// @Tags API Keys
+// @Produce json
+// @Success 200 {object} []database.APIKey
+// @Security BearerAuth
+// @Router /api/v1/me/api-keys [get]
+func (app *application) getAPIKeys(c *gin.Context) {
+	user := app.getUserFromContext(c)
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// revokeAPIKey revokes an API key of the current user
+//
+// @Summary Revokes an API key
+// @Description Revokes one of the current user's API keys. Requests made with it are rejected from then on.
+// @Tags API Keys
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me/api-keys/{id} [delete]
+func (app *application) revokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key id"})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	revoked, err := app.models.APIKeys.Revoke(id, user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	app.audit(c, user, "apikey.revoke", "apikey:"+strconv.Itoa(id), "")
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	"rest-go-gin/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// audit records a security relevant action. actor is nil for actions the
+// system takes on its own. Failures are logged but never fail the request.
+func (app *application) audit(c *gin.Context, actor *database.User, action, subject, details string) {
+	entry := &database.AuditEntry{
+		Action:  action,
+		Subject: subject,
+		IP:      c.ClientIP(),
+		Details: details,
+	}
+	if actor != nil {
+		entry.ActorId = &actor.Id
+	}
+
+	if err := app.models.Audit.Insert(entry); err != nil {
+		log.Printf("failed to write audit entry %s for %s: %v", action, subject, err)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"rest-go-gin/internal/database"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -22,7 +24,13 @@ type loginRequest struct {
 }
 
 type loginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
 func (app *application) login(c *gin.Context){
@@ -32,35 +40,127 @@ func (app *application) login(c *gin.Context){
 		return
 	}
 
+	keys := loginKeys(c, auth.Email)
+	if !app.checkLoginAllowed(c, keys) {
+		return
+	}
+
 	existingUser, err := app.models.Users.GetByEmail(auth.Email)
 
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"Error":"Something went wrong"})
+		return
+	}
+
 	if existingUser == nil {
+		app.recordLoginFailure(c, keys)
 		c.JSON(http.StatusUnauthorized, gin.H{"Error":"Invalid email or password"})
 		return
 	}
 
+	err = bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(auth.Password))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"Error":"Something went wrong"})
+		app.recordLoginFailure(c, keys)
+		c.JSON(http.StatusUnauthorized, gin.H{"Error":"Invalid email or password"})
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(auth.Password))
+	app.completeLogin(c, existingUser)
+}
+
+// refresh exchanges a refresh token for a new access token
+//
+// @Summary Refreshes an access token
+// @Description Exchanges a refresh token for a new access token and a new refresh token. Every refresh token can be used once; replaying a used token revokes every token issued from the same login.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body refreshRequest true "Refresh token"
+// @Success 200 {object} loginResponse
+// @Failure 401 {object} map[string]string
+// @Router /api/v1/auth/refresh [post]
+func (app *application) refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, err := app.models.RefreshTokens.GetByHash(hashToken(req.RefreshToken))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"Error":"Invalid email or password"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId":existingUser.Id,
-		"expr" : time.Now().Add(time.Hour * 72).Unix(),
-	})
+	if current == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if current.RevokedAt != nil {
+		app.revokeRefreshFamily(current.FamilyId)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token has expired"})
+		return
+	}
+
+	user, err := app.models.Users.Get(current.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	// Only logging in again restores an account scheduled for deletion.
+	if user.DeletionScheduledAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Account is scheduled for deletion"})
+		return
+	}
+
+	refreshToken, next, err := app.newRefreshToken(user.Id, current.FamilyId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	if err := app.models.RefreshTokens.Rotate(current, next); err != nil {
+		if errors.Is(err, database.ErrRefreshTokenReused) {
+			app.revokeRefreshFamily(current.FamilyId)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
 
-	tokenString, err := token.SignedString([]byte(app.jwtSecret))
+	accessToken, err := app.newAccessToken(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error":"Error generating token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
 		return
 	}
-	c.JSON(http.StatusOK, loginResponse{Token: tokenString})
+
+	c.JSON(http.StatusOK, loginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(app.accessTokenTTL.Seconds()),
+	})
+}
+
+// revokeRefreshFamily is called when a used refresh token is presented again.
+// Either the client or an attacker holds a stolen copy, so every token in the
+// family is revoked and the user has to log in again.
+func (app *application) revokeRefreshFamily(familyId string) {
+	if err := app.models.RefreshTokens.RevokeFamily(familyId); err != nil {
+		log.Printf("failed to revoke refresh token family: %v", err)
+	}
 }
 
 func (app *application) registerUser(c *gin.Context) {
@@ -90,5 +190,104 @@ func (app *application) registerUser(c *gin.Context) {
 		return
 	}
 
+	if err := app.sendVerificationEmail(&user, user.Email); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", user.Id, err)
+	}
+
 	c.JSON(http.StatusCreated, user)
-}
\ No newline at end of file
+}
+type logoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// logout revokes the access token used for the request
+//
+// @Summary Logs out the current session
+// @Description Revokes the access token used for the request. If a refresh token is sent, every token issued from the same login is revoked too.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body logoutRequest false "Refresh token of the session"
+// @Success 204
+// @Security BearerAuth
+// @Router /api/v1/auth/logout [post]
+func (app *application) logout(c *gin.Context) {
+	var req logoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+	claims := app.getClaimsFromContext(c)
+	jti, _ := claims["jti"].(string)
+
+	if err := app.revocations.RevokeToken(jti, user.Id, claimTime(claims, "exp")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	if req.RefreshToken != "" {
+		token, err := app.models.RefreshTokens.GetByHash(hashToken(req.RefreshToken))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		if token != nil && token.UserId == user.Id {
+			if err := app.models.RefreshTokens.RevokeFamily(token.FamilyId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+				return
+			}
+		}
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// logoutAll ends every session of the current user
+//
+// @Summary Logs out every session
+// @Description Revokes all refresh tokens of the current user and every access token issued before now.
+// @Tags Auth
+// @Produce json
+// @Success 204
+// @Security BearerAuth
+// @Router /api/v1/auth/logout-all [post]
+func (app *application) logoutAll(c *gin.Context) {
+	user := app.getUserFromContext(c)
+
+	if err := app.revokeAllSessions(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// revokeAllSessions logs a user out everywhere, e.g. after a password change.
+func (app *application) revokeAllSessions(userId int) error {
+	if err := app.models.RefreshTokens.RevokeAllForUser(userId); err != nil {
+		return err
+	}
+	return app.revocations.RevokeSessions(userId)
+}
+
+// getJWKS publishes the public signing keys
+//
+// @Summary Returns the JSON Web Key Set
+// @Description Returns the public keys access tokens are signed with, so other services can verify them. HMAC secrets are never published.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string][]jsonWebKey
+// @Router /.well-known/jwks.json [get]
+func (app *application) getJWKS(c *gin.Context) {
+	keys, err := app.keys.jwks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load signing keys"})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/ical"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const calendarProdId = "-//rest-go-gin//Events//EN"
+
+type createCalendarFeedRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type createCalendarFeedResponse struct {
+	*database.CalendarFeed
+	URL string `json:"url"`
+}
+
+// eventUID returns the iCalendar UID of an event, which stays the same
+// across changes.
+func (app *application) eventUID(event *database.Event) string {
+	host := "rest-go-gin"
+	if u, err := url.Parse(app.baseURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	return fmt.Sprintf("event-%d@%s", event.Id, host)
+}
+
+// icsEvents converts events to VEVENTs. A recurring event becomes its series,
+// with cancelled occurrences excluded and changed ones overridden.
+func (app *application) icsEvents(events []*database.Event) ([]*ical.Event, error) {
+	var vevents []*ical.Event
+	for _, event := range events {
+		rule, err := event.Rule()
+		if err != nil {
+			return nil, err
+		}
+
+		series := &ical.Event{
+			UID:         app.eventUID(event),
+			Sequence:    event.Sequence,
+			Stamp:       event.UpdatedAt,
+			Start:       event.StartsAt,
+			End:         event.EndsAt,
+			TimeZone:    event.TimeZone,
+			Summary:     event.Name,
+			Description: event.Description,
+			Location:    event.Location,
+			URL:         fmt.Sprintf("%s/api/v1/events/%d", app.baseURL, event.Id),
+			Categories:  event.Tags,
+		}
+		if rule != nil {
+			series.RRule = rule.ICalendar(event.Zone())
+		}
+		if event.Status == database.EventCancelled {
+			series.Status = ical.StatusCancelled
+		}
+		vevents = append(vevents, series)
+
+		exceptions, err := app.models.Events.Exceptions(event)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, occurrence := range exceptions {
+			scheduled, err := event.ScheduledStart(occurrence.Occurrence)
+			if err != nil {
+				return nil, err
+			}
+
+			if occurrence.Cancelled {
+				series.ExDates = append(series.ExDates, scheduled)
+				continue
+			}
+
+			override := *series
+			override.RRule, override.ExDates = "", nil
+			override.RecurrenceId = scheduled
+			override.Start, override.End = occurrence.StartsAt, occurrence.EndsAt
+			override.Summary = occurrence.Name
+			override.Description = occurrence.Description
+			override.Location = occurrence.Location
+			vevents = append(vevents, &override)
+		}
+	}
+	return vevents, nil
+}
+
+// writeCalendar writes the events as an iCalendar response.
+func (app *application) writeCalendar(c *gin.Context, name string, events []*database.Event) {
+	vevents, err := app.icsEvents(events)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	calendar := &ical.Calendar{ProdId: calendarProdId, Name: name, Events: vevents}
+
+	var b bytes.Buffer
+	if _, err := calendar.WriteTo(&b); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write calendar"})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", b.Bytes())
+}
+
+// getEventICS exports an event to iCalendar
+//
+// @Summary Exports an event as iCalendar
+// @Description Returns the event as an RFC 5545 calendar for calendar apps to import. Recurring events are exported as a series with their exceptions. The UID stays the same and SEQUENCE grows when the event changes, so importing again updates the event.
+// @Tags Calendar
+// @Produce text/calendar
+// @Param id path int true "Event ID"
+// @Param slug query string false "Slug of an unlisted event, for callers who neither own nor attend it"
+// @Success 200 {string} string "iCalendar data"
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/events/{id}/ics [get]
+func (app *application) getEventICS(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	event, err := app.models.Events.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	if !app.checkCanView(c, event) {
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="event-%d.ics"`, event.Id))
+	app.writeCalendar(c, "", []*database.Event{event})
+}
+
+// getCalendarFeed serves a calendar feed
+//
+// @Summary Returns a calendar feed
+// @Description Returns the events the owner of the feed owns or attends as an RFC 5545 calendar, for calendar apps to subscribe to; drafts of other owners are left out. The URL itself is the credential; revoked feeds and feeds of accounts scheduled for deletion are not found.
+// @Tags Calendar
+// @Produce text/calendar
+// @Param feed path string true "Feed token followed by .ics"
+// @Success 200 {string} string "iCalendar data"
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/calendar/{feed} [get]
+func (app *application) getCalendarFeed(c *gin.Context) {
+	token, ok := strings.CutSuffix(c.Param("feed"), ".ics")
+	if !ok || token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	feed, err := app.models.CalendarFeeds.GetByHash(hashToken(token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if feed == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	// Feeds of an account waiting to be deleted stop with its sessions.
+	user, err := app.models.Users.Get(feed.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if user == nil || user.DeletionScheduledAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	owned, err := app.models.Events.GetAllByOwner(feed.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	attending, err := app.models.Attendees.GetEventsByAttendee(feed.UserId, database.EventFilter{Statuses: listedStatuses})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	events := owned
+	seen := map[int]bool{}
+	for _, event := range owned {
+		seen[event.Id] = true
+	}
+	for _, event := range attending {
+		if !seen[event.Id] {
+			seen[event.Id] = true
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].StartsAt.Before(events[j].StartsAt) })
+
+	if err := app.models.CalendarFeeds.Touch(feed.Id); err != nil {
+		log.Printf("failed to record use of calendar feed %d: %v", feed.Id, err)
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	app.writeCalendar(c, feed.Name, events)
+}
+
+// createCalendarFeed creates a calendar feed URL
+//
+// @Summary Creates a calendar feed
+// @Description Creates a secret URL that serves the events the current user owns or attends as a calendar, for calendar apps that cannot log in. The URL is only returned once; revoke the feed if it leaks.
+// @Tags Calendar
+// @Accept json
+// @Produce json
+// @Param request body createCalendarFeedRequest true "Feed name"
+// @Success 201 {object} createCalendarFeedResponse
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me/calendar-feeds [post]
+func (app *application) createCalendarFeed(c *gin.Context) {
+	var req createCalendarFeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	token, err := generateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+		return
+	}
+
+	feed := &database.CalendarFeed{
+		UserId:    user.Id,
+		Name:      req.Name,
+		TokenHash: hashToken(token),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := app.models.CalendarFeeds.Insert(feed); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+		return
+	}
+
+	app.audit(c, user, "calendarfeed.create", "calendarfeed:"+strconv.Itoa(feed.Id), "")
+
+	c.JSON(http.StatusCreated, createCalendarFeedResponse{
+		CalendarFeed: feed,
+		URL:          app.baseURL + "/api/v1/calendar/" + token + ".ics",
+	})
+}
+
+// getCalendarFeeds lists the calendar feeds of the current user
+//
+// @Summary Lists calendar feeds
+// @Description Returns the active calendar feeds of the current user. Their URLs are never returned again.
+// @Tags Calendar
+// @Produce json
+// @Success 200 {object} []database.CalendarFeed
+// @Security BearerAuth
+// @Router /api/v1/me/calendar-feeds [get]
+func (app *application) getCalendarFeeds(c *gin.Context) {
+	user := app.getUserFromContext(c)
+
+	feeds, err := app.models.CalendarFeeds.GetAllForUser(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve calendar feeds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, feeds)
+}
+
+// revokeCalendarFeed revokes a calendar feed of the current user
+//
+// @Summary Revokes a calendar feed
+// @Description Revokes one of the current user's calendar feeds. Its URL stops working right away.
+// @Tags Calendar
+// @Param id path int true "Calendar feed ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me/calendar-feeds/{id} [delete]
+func (app *application) revokeCalendarFeed(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid calendar feed id"})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	revoked, err := app.models.CalendarFeeds.Revoke(id, user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke calendar feed"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	app.audit(c, user, "calendarfeed.revoke", "calendarfeed:"+strconv.Itoa(id), "")
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -4,6 +4,7 @@ import (
 	"rest-go-gin/internal/database"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 )
 
 func(app *application) getUserFromContext(c *gin.Context) *database.User{
@@ -18,4 +19,34 @@ func(app *application) getUserFromContext(c *gin.Context) *database.User{
 	}
 
 	return user
-}
\ No newline at end of file
+}
+
+func (app *application) getClaimsFromContext(c *gin.Context) jwt.MapClaims {
+	contextClaims, exists := c.Get("claims")
+
+	if !exists {
+		return jwt.MapClaims{}
+	}
+	claims, ok := contextClaims.(jwt.MapClaims)
+	if !ok {
+		return jwt.MapClaims{}
+	}
+
+	return claims
+}
+
+// getAPIKeyFromContext returns the API key the request was authenticated
+// with, or nil for requests that used a bearer token.
+func (app *application) getAPIKeyFromContext(c *gin.Context) *database.APIKey {
+	contextKey, exists := c.Get("apiKey")
+
+	if !exists {
+		return nil
+	}
+	key, ok := contextKey.(*database.APIKey)
+	if !ok {
+		return nil
+	}
+
+	return key
+}
@@ -1,23 +1,49 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"rest-go-gin/internal/database"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func(app *application) createEvent(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
 	var event database.Event
 
 	if err := c.ShouldBindJSON(&event); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+		return
+	}
+
+	if err := validateTags(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+		return
+	}
+
+	if err := validateRecurrence(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+		return
 	}
 
 	user := app.getUserFromContext(c)
 	event.OwnerId = user.Id
+	// Events start out as drafts and change status through publish and
+	// cancel only.
+	event.Status = database.EventDraft
+	event.CancelledAt = nil
+	event.CancellationReason = ""
+	// Slugs are generated, never chosen by the client.
+	event.Slug = nil
 
 	err := app.models.Events.Insert(&event)
 
@@ -27,26 +53,156 @@ func(app *application) createEvent(c *gin.Context) {
 		return
 	}
 
+	event.In(loc)
 	c.JSON(http.StatusCreated, event)
 }
 
+type eventListResponse struct {
+	Data       []*database.Event `json:"data"`
+	NextCursor *string           `json:"next_cursor"`
+	Total      int               `json:"total"`
+}
+
+const (
+	defaultEventPageSize = 20
+	maxEventPageSize     = 100
+)
+
 // getEvents return all events
 // 
-// @Summary Returns all events
-// @Description Returns all events
+// @Summary Returns events
+// @Description Returns a page of events. Pages are chained with the next_cursor of the previous response, which stays valid while events are added or removed. total counts every event matching the filters.
+// @Description Unlisted and private events are only listed for their owner, their attendees and moderators, who have to send credentials.
+// @Description Send Accept: text/csv or application/x-ndjson to export every matching event instead of a page; the export is streamed row by row, with the columns picked by columns, and ignores limit and cursor.
 // @Tags Events
 // @Accept Json
 // @Produce json
-// @Success 200 {object} []database.Event
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param limit query int false "Page size, 1 to 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param from query string false "Earliest start date, YYYY-MM-DD"
+// @Param to query string false "Latest start date, YYYY-MM-DD"
+// @Param location query string false "Part of the location"
+// @Param owner query int false "Owner user ID"
+// @Param q query string false "Text in the name or description"
+// @Param tag query string false "Comma separated tags"
+// @Param match query string false "Whether events need any or all of the tags" Enums(any, all) default(any)
+// @Param status query string false "Comma separated statuses out of draft, published, cancelled and completed; all but draft by default"
+// @Param sort query string false "Comma separated fields out of id, date, name and location, where date is the start time; prefix with - for descending" default(date)
+// @Param tz query string false "IANA time zone to render times in and to read from and to in; by default times are rendered in the zone of each event and dates read in UTC"
+// @Param columns query string false "Comma separated columns of CSV and NDJSON exports out of id, ownerId, name, description, startsAt, endsAt, timeZone, location, capacity, rsvpPolicy, rrule, sequence, updatedAt, visibility, status and tags; all by default"
+// @Success 200 {object} eventListResponse
+// @Failure 400 {object} map[string]string
 // @Router /api/v1/events [get]
 func (app *application) getAllEvents(c *gin.Context){
-	events, err := app.models.Events.GetAll()
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	opts, err := parseEventListOptions(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+		return
+	}
+	opts.VisibleTo = app.visibleTo(c)
+
+	if format := exportFormat(c); format != gin.MIMEJSON {
+		columns, err := parseExportColumns(c, eventExportColumns)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+			return
+		}
+
+		export := newExporter(c, format, "events", columns)
+		export.finish(app.models.Events.Each(opts.EventFilter, opts.Sort, func(event *database.Event) error {
+			event.In(loc)
+			return export.write(event)
+		}))
+		return
+	}
+
+	page, err := app.models.Events.List(*opts)
+
+	if errors.Is(err, database.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid cursor"})
+		return
+	}
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to retrieve events"})
+		return
 	}
 
-	c.JSON(http.StatusOK, events)
+	eventsIn(loc, page.Events)
+	response := eventListResponse{Data: page.Events, Total: page.Total}
+	if page.NextCursor != "" {
+		response.NextCursor = &page.NextCursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseEventListOptions reads the listing options from the query. Dates are
+// read in loc, or in UTC if loc is nil.
+func parseEventListOptions(c *gin.Context, loc *time.Location) (*database.EventListOptions, error) {
+	opts := &database.EventListOptions{
+		EventFilter: database.EventFilter{
+			Location: c.Query("location"),
+			Query:    c.Query("q"),
+		},
+		Limit:  defaultEventPageSize,
+		Cursor: c.Query("cursor"),
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxEventPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxEventPageSize)
+		}
+		opts.Limit = n
+	}
+
+	if from := c.Query("from"); from != "" {
+		day, err := parseDayIn(from, loc)
+		if err != nil {
+			return nil, errors.New("from must be a date in YYYY-MM-DD form")
+		}
+		opts.From = day
+	}
+
+	if to := c.Query("to"); to != "" {
+		day, err := parseDayIn(to, loc)
+		if err != nil {
+			return nil, errors.New("to must be a date in YYYY-MM-DD form")
+		}
+		opts.To = day.AddDate(0, 0, 1)
+	}
+
+	if owner := c.Query("owner"); owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil || id < 1 {
+			return nil, errors.New("owner must be a user ID")
+		}
+		opts.OwnerId = id
+	}
+
+	if err := parseTagFilter(c, &opts.EventFilter); err != nil {
+		return nil, err
+	}
+
+	if err := parseStatusFilter(c, &opts.EventFilter); err != nil {
+		return nil, err
+	}
+
+	sort, err := database.ParseEventSort(c.Query("sort"))
+	if err != nil {
+		return nil, err
+	}
+	opts.Sort = sort
+
+	return opts, nil
 }
 
 func (app *application) getEvent(c *gin.Context){
@@ -54,17 +210,63 @@ func (app *application) getEvent(c *gin.Context){
 
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid event ID"})
+		return
+	}
+
+	loc, ok := renderZone(c)
+	if !ok {
+		return
 	}
+
 	event, err := app.models.Events.Get(id)
 
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to retrieve event"})
+		return
+	}
+
 	if event == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error":"Event not found"})
+		return
 	}
 
+	if !app.checkCanView(c, event) {
+		return
+	}
+
+	event.In(loc)
+	c.JSON(http.StatusOK, event)
+}
+
+// getEventBySlug returns an unlisted event
+//
+// @Summary Returns an unlisted event by its slug
+// @Description Returns the unlisted event the slug links to, to anybody who has the link. Pass the slug as the slug query parameter to read its occurrences, attendees or calendar, or to sign up. Making the event public or private and then unlisted again gives it a new slug.
+// @Tags Events
+// @Produce json
+// @Param slug path string true "Slug of the unlisted event"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of the event"
+// @Success 200 {object} database.Event
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/events/slug/{slug} [get]
+func (app *application) getEventBySlug(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	event, err := app.models.Events.GetBySlug(c.Param("slug"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to retrieve event"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
 	}
 
+	event.In(loc)
 	c.JSON(http.StatusOK, event)
 }
 
@@ -72,9 +274,14 @@ func (app *application) updateEvent(c *gin.Context){
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid event ID"})
+		return
+	}
+
+	loc, ok := renderZone(c)
+	if !ok {
+		return
 	}
 
-	user := app.getUserFromContext(c)
 	existingEvent, err := app.models.Events.Get(id)
 
 	if err != nil {
@@ -87,11 +294,15 @@ func (app *application) updateEvent(c *gin.Context){
 		return
 	}
 
-	if existingEvent.OwnerId != user.Id{
+	if !app.canManageEvent(c, existingEvent) {
 		c.JSON(http.StatusForbidden, gin.H{"error":"You are not authorized to update this event"})
 		return
 	}
 
+	if !checkOpen(c, existingEvent) {
+		return
+	}
+
 	updatedEvent := &database.Event{}
 
 	if err := c.ShouldBindJSON(updatedEvent); err != nil {
@@ -99,14 +310,39 @@ func (app *application) updateEvent(c *gin.Context){
 		return
 	}
 
-	
+	if err := validateTags(updatedEvent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+		return
+	}
+
+	if err := validateRecurrence(updatedEvent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+		return
+	}
 
 	updatedEvent.Id = id
+	updatedEvent.OwnerId = existingEvent.OwnerId
+	// An event that stays unlisted keeps its slug, so links to it keep
+	// working.
+	updatedEvent.Slug = existingEvent.Slug
+	updatedEvent.Status = existingEvent.Status
+	updatedEvent.CancelledAt = existingEvent.CancelledAt
+	updatedEvent.CancellationReason = existingEvent.CancellationReason
+
+	promoted, err := app.models.Events.Update(updatedEvent)
+	if errors.Is(err, database.ErrScheduleInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error":"The dates of a recurring event cannot be changed once it has attendees or changed occurrences; create a new event instead"})
+		return
+	}
 
-	if err := app.models.Events.Update(updatedEvent); err != nil {
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to update event"})
 		return
 	}
+
+	app.notifyPromoted(updatedEvent, promoted)
+
+	updatedEvent.In(loc)
 	c.JSON(http.StatusOK, updatedEvent)
 }
 
@@ -114,9 +350,9 @@ func (app *application) deleteEvent(c *gin.Context){
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid event ID"})
+		return
 	}
 
-	user := app.getUserFromContext(c)
 	existingEvent, err := app.models.Events.Get(id)
 
 	if err != nil {
@@ -128,13 +364,21 @@ func (app *application) deleteEvent(c *gin.Context){
 		c.JSON(http.StatusNotFound, gin.H{"error":"Event not found"})
 		return
 	}
-	if existingEvent.OwnerId != user.Id{
+	if !app.canManageEvent(c, existingEvent) {
 		c.JSON(http.StatusForbidden, gin.H{"error":"You are not authorized to delete this event"})
 		return
 	}
 
+	// Deleting hides the event from its attendees without a word, so
+	// published events are cancelled first, which tells them.
+	if existingEvent.Status == database.EventPublished {
+		c.JSON(http.StatusConflict, gin.H{"error":"Published events must be cancelled before they are deleted"})
+		return
+	}
+
 	if err := app.models.Events.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to delete event"})
+		return
 	}
 
 	c.JSON(http.StatusNoContent, nil)
@@ -164,6 +408,7 @@ func (app *application) addAttendeeToEvent(c *gin.Context){
 
 	if event == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error":"Event not found"})
+		return
 	}
 
 	userToAdd, err := app.models.Users.Get(userId)
@@ -174,33 +419,36 @@ func (app *application) addAttendeeToEvent(c *gin.Context){
 	}
 	if userToAdd == nil {
 		c.JSON(http.StatusNotFound, gin.H{"error":"User not found"})
+		return
 	}
 
-	user := app.getUserFromContext(c)
-
-	if event.OwnerId != user.Id{
+	if !app.canManageEvent(c, event) {
 		c.JSON(http.StatusForbidden, gin.H{"error":"You are not authorized to add an attendee"})
 		return
 	}
 
-
-	existingAttendee, err := app.models.Attendees.GetByEventAndAttendee(event.Id, userToAdd.Id)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to retrieve attendee"})
+	if !checkOpen(c, event) {
 		return
 	}
-	if existingAttendee != nil {
-		c.JSON(http.StatusConflict, gin.H{"error":"Attendee already exists"})
+
+	occurrence, ok := app.occurrenceParam(c, event, false)
+	if !ok {
+		return
 	}
 
 	attendee := database.Attendee{
 
 		EventId: event.Id,
 		UserId: userToAdd.Id,
+		Occurrence: occurrence,
 	}
 
-	_, err = app.models.Attendees.Insert(&attendee)
+	err = app.models.Attendees.Add(&attendee)
+
+	if errors.Is(err, database.ErrAttendeeExists) {
+		c.JSON(http.StatusConflict, gin.H{"error":"Attendee already exists"})
+		return
+	}
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to add attendee"})
@@ -211,6 +459,23 @@ func (app *application) addAttendeeToEvent(c *gin.Context){
 
 }
 
+// getAttendeesForEvent lists the attendees of an event
+//
+// @Summary Returns the attendees of an event
+// @Description Returns the attendees of the event, or of one occurrence of a recurring event: confirmed attendees first, then the waitlist in the order it will be promoted, then attendees awaiting approval. Email addresses are only shown to those who manage the event. They can send Accept: text/csv or application/x-ndjson to download the list; it is streamed row by row, with the columns picked by columns.
+// @Tags Attendees
+// @Produce json
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param id path int true "Event ID"
+// @Param slug query string false "Slug of an unlisted event, for callers who neither own nor attend it"
+// @Param occurrence query string false "Occurrence date, YYYY-MM-DD, for recurring events"
+// @Param columns query string false "Comma separated columns of CSV and NDJSON exports out of id, name, email, status, rsvp and waitlistPosition; all by default"
+// @Success 200 {object} []database.EventAttendee
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/events/{id}/attendees [get]
 func (app *application) getAttendeesForEvent(c *gin.Context){
 
 	id, err := strconv.Atoi(c.Param("id"))
@@ -219,12 +484,66 @@ func (app *application) getAttendeesForEvent(c *gin.Context){
 		return
 	}
 
-	users, err := app.models.Attendees.GetAttendeesByEvent(id)
+	event, err := app.models.Events.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error":"Event not found"})
+		return
+	}
+
+	if !app.checkCanView(c, event) {
+		return
+	}
+
+	occurrence, ok := app.occurrenceParam(c, event, true)
+	if !ok {
+		return
+	}
+
+	// Attendee lists are exported for check-in, which is up to those who
+	// run the event; others do not see email addresses.
+	manager := app.canManageEvent(c, event)
+
+	if format := exportFormat(c); format != gin.MIMEJSON {
+		if !manager {
+			c.JSON(http.StatusForbidden, gin.H{"error":"You are not authorized to export the attendees of this event"})
+			return
+		}
+
+		columns, err := parseExportColumns(c, attendeeExportColumns)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error":err.Error()})
+			return
+		}
+
+		filename := fmt.Sprintf("event-%d-attendees", id)
+		if occurrence != "" {
+			filename += "-" + occurrence
+		}
+
+		export := newExporter(c, format, filename, columns)
+		export.finish(app.models.Attendees.EachAttendeeByEvent(id, occurrence, func(attendee *database.EventAttendee) error {
+			return export.write(attendee)
+		}))
+		return
+	}
+
+	users, err := app.models.Attendees.GetAttendeesByEvent(id, occurrence)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to retrieve attendee"})
 		return
 	}
 
+	if !manager {
+		for _, user := range users {
+			user.Email = ""
+		}
+	}
+
 	c.JSON(http.StatusOK, users)
 }
 
@@ -252,18 +571,28 @@ func (app *application) deleteAttendeeFromEvent( c *gin.Context){
 			return
 	}
 
-	user := app.getUserFromContext(c)
-	if event.OwnerId != user.Id {
-		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to add content"})
+	if !app.canManageEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error":"You are not authorized to delete an attendee"})
+		return
 	}
 
+	if !checkOpen(c, event) {
+		return
+	}
+
+	occurrence, ok := app.occurrenceParam(c, event, true)
+	if !ok {
+		return
+	}
 
-	err = app.models.Attendees.Delete(userId, id)
+	promoted, err := app.models.Attendees.Delete(userId, id, occurrence)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attendee"})
 		return
 	}
 
+	app.notifyPromoted(event, promoted)
+
 	c.JSON(http.StatusNoContent, nil)
 }
 
@@ -273,13 +602,92 @@ func (app *application) getEventsByAttendee(c *gin.Context){
 		c.JSON(http.StatusBadRequest, gin.H{"error":"Invalid attendee id"})
 		return
 	}
-	
-	events, err := app.models.Attendees.GetEventsByAttendee(id)
+
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	events, err := app.models.Attendees.GetEventsByAttendee(id, database.EventFilter{Statuses: listedStatuses, VisibleTo: app.visibleTo(c)})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error":"Failed to get events"})
 		return
 	}
 
+	eventsIn(loc, events)
 	c.JSON(http.StatusOK, events)
 
-}
\ No newline at end of file
+}
+type eventSearchResponse struct {
+	Data       []*database.EventSearchResult `json:"data"`
+	NextCursor *string                       `json:"next_cursor"`
+	Total      int                           `json:"total"`
+}
+
+// searchEvents finds events by keyword
+//
+// @Summary Searches events
+// @Description Full-text search over event names, descriptions and locations, best matches first. Words match whole terms, a trailing * matches a prefix and double quotes match a phrase. Highlights are HTML with the matches wrapped in mark elements. Unlisted and private events are only found by their owner, their attendees and moderators, who have to send credentials.
+// @Tags Events
+// @Produce json
+// @Param q query string true "Search query"
+// @Param limit query int false "Page size, 1 to 100" default(20)
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of each event"
+// @Success 200 {object} eventSearchResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/v1/events/search [get]
+func (app *application) searchEvents(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	query, err := database.BuildFTSQuery(c.Query("q"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must contain at least one word"})
+		return
+	}
+
+	limit := defaultEventPageSize
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxEventPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxEventPageSize)})
+			return
+		}
+		limit = n
+	}
+
+	// Relevance has no stable key to continue from, so search cursors are
+	// plain offsets.
+	offset := 0
+	if cursor := c.Query("cursor"); cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			offset, err = strconv.Atoi(string(b))
+		}
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	page, err := app.models.Events.Search(query, database.EventFilter{Statuses: listedStatuses, VisibleTo: app.visibleTo(c)}, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search events"})
+		return
+	}
+
+	for _, result := range page.Results {
+		result.In(loc)
+	}
+
+	response := eventSearchResponse{Data: page.Results, Total: page.Total}
+	if next := offset + limit; next < page.Total {
+		cursor := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(next)))
+		response.NextCursor = &cursor
+	}
+
+	c.JSON(http.StatusOK, response)
+}
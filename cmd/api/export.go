@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// exportFlushRows is how many rows are buffered before an export is flushed
+// to the client.
+const exportFlushRows = 100
+
+// exportColumn is a column of an export: its name, which is the JSON name
+// of the field, and how to read its value from a row.
+type exportColumn[T any] struct {
+	name  string
+	value func(T) interface{}
+}
+
+var eventExportColumns = []exportColumn[*database.Event]{
+	{"id", func(e *database.Event) interface{} { return e.Id }},
+	{"ownerId", func(e *database.Event) interface{} { return e.OwnerId }},
+	{"name", func(e *database.Event) interface{} { return e.Name }},
+	{"description", func(e *database.Event) interface{} { return e.Description }},
+	{"startsAt", func(e *database.Event) interface{} { return e.StartsAt }},
+	{"endsAt", func(e *database.Event) interface{} { return e.EndsAt }},
+	{"timeZone", func(e *database.Event) interface{} { return e.TimeZone }},
+	{"location", func(e *database.Event) interface{} { return e.Location }},
+	{"capacity", func(e *database.Event) interface{} { return e.Capacity }},
+	{"rsvpPolicy", func(e *database.Event) interface{} { return e.RSVPPolicy }},
+	{"rrule", func(e *database.Event) interface{} { return e.RRule }},
+	{"sequence", func(e *database.Event) interface{} { return e.Sequence }},
+	{"updatedAt", func(e *database.Event) interface{} { return e.UpdatedAt }},
+	{"visibility", func(e *database.Event) interface{} { return e.Visibility }},
+	{"status", func(e *database.Event) interface{} { return e.Status }},
+	{"tags", func(e *database.Event) interface{} { return e.Tags }},
+}
+
+var attendeeExportColumns = []exportColumn[*database.EventAttendee]{
+	{"id", func(a *database.EventAttendee) interface{} { return a.Id }},
+	{"name", func(a *database.EventAttendee) interface{} { return a.Name }},
+	{"email", func(a *database.EventAttendee) interface{} { return a.Email }},
+	{"status", func(a *database.EventAttendee) interface{} { return a.Status }},
+	{"rsvp", func(a *database.EventAttendee) interface{} { return a.RSVP }},
+	{"waitlistPosition", func(a *database.EventAttendee) interface{} { return a.WaitlistPosition }},
+}
+
+// exportFormat returns the format the client accepts out of JSON, CSV and
+// NDJSON. Clients that accept none of them get JSON.
+func exportFormat(c *gin.Context) string {
+	if format := c.NegotiateFormat(gin.MIMEJSON, mimeCSV, mimeNDJSON); format != "" {
+		return format
+	}
+	return gin.MIMEJSON
+}
+
+// parseExportColumns returns the columns named by the columns query
+// parameter, in the order given, or all of them if there is none.
+func parseExportColumns[T any](c *gin.Context, all []exportColumn[T]) ([]exportColumn[T], error) {
+	param := c.Query("columns")
+	if param == "" {
+		return all, nil
+	}
+
+	names := make([]string, len(all))
+	for i, column := range all {
+		names[i] = column.name
+	}
+
+	var columns []exportColumn[T]
+	seen := map[string]bool{}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+
+		found := false
+		for _, column := range all {
+			if column.name == name {
+				columns = append(columns, column)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("columns must be out of %s", strings.Join(names, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is selected twice", name)
+		}
+		seen[name] = true
+	}
+
+	return columns, nil
+}
+
+// exporter streams rows as CSV or NDJSON. The response starts with the
+// first row, so errors before it can still be answered with a status.
+type exporter[T any] struct {
+	c        *gin.Context
+	format   string
+	filename string
+	columns  []exportColumn[T]
+
+	csv     *csv.Writer
+	started bool
+	rows    int
+}
+
+func newExporter[T any](c *gin.Context, format, filename string, columns []exportColumn[T]) *exporter[T] {
+	return &exporter[T]{c: c, format: format, filename: filename, columns: columns}
+}
+
+func (e *exporter[T]) start() error {
+	e.started = true
+
+	if e.format == mimeCSV {
+		e.c.Header("Content-Type", "text/csv; charset=utf-8")
+		e.c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, e.filename))
+	} else {
+		e.c.Header("Content-Type", mimeNDJSON)
+	}
+	e.c.Status(http.StatusOK)
+
+	if e.format != mimeCSV {
+		return nil
+	}
+
+	e.csv = csv.NewWriter(e.c.Writer)
+	header := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		header[i] = column.name
+	}
+	return e.csv.Write(header)
+}
+
+// write writes a row, flushing every exportFlushRows rows.
+func (e *exporter[T]) write(row T) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if e.format == mimeCSV {
+		err = e.writeCSV(row)
+	} else {
+		err = e.writeNDJSON(row)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *exporter[T]) writeCSV(row T) error {
+	record := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		record[i] = csvValue(column.value(row))
+	}
+	return e.csv.Write(record)
+}
+
+// writeNDJSON writes the row as a JSON object on a line of its own, with
+// the columns in the order they were selected.
+func (e *exporter[T]) writeNDJSON(row T) error {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, column := range e.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value, err := json.Marshal(column.value(row))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%q:%s", column.name, value)
+	}
+	b.WriteString("}\n")
+
+	_, err := e.c.Writer.WriteString(b.String())
+	return err
+}
+
+func (e *exporter[T]) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	e.c.Writer.Flush()
+	return nil
+}
+
+// finish ends the export after the rows were read with err. Once rows were
+// sent, an error can only cut the export short.
+func (e *exporter[T]) finish(err error) {
+	if err != nil {
+		if !e.started {
+			e.c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export"})
+			return
+		}
+		log.Printf("export of %s failed after %d rows: %v", e.filename, e.rows, err)
+		e.flush()
+		return
+	}
+
+	if !e.started {
+		if err := e.start(); err != nil {
+			log.Printf("export of %s failed: %v", e.filename, err)
+			return
+		}
+	}
+	if err := e.flush(); err != nil {
+		log.Printf("export of %s failed: %v", e.filename, err)
+	}
+}
+
+// csvValue formats a value for CSV: times in RFC 3339, tags separated by
+// semicolons and nil as empty.
+// Text that spreadsheets would run as a formula is prefixed with a quote.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case database.Tags:
+		return csvValue(strings.Join(v, ";"))
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/ical"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxImportSize limits the size of an imported file.
+const maxImportSize = 5 << 20
+
+const (
+	importCSV = "csv"
+	importICS = "ics"
+)
+
+// importColumns are the columns a CSV import may have, in the order they
+// are documented. Names are matched case-insensitively.
+var importColumns = []string{"name", "description", "location", "startsAt", "endsAt", "timeZone", "capacity", "rsvpPolicy", "visibility", "rrule", "tags", "attendees"}
+
+var requiredImportColumns = []string{"name", "description", "location", "startsAt", "endsAt"}
+
+// importTimeLayouts are the forms times without an offset may take in a
+// CSV import. They are read in the event's time zone.
+var importTimeLayouts = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+type importRowError struct {
+	// Row is the line of the CSV record, counting the header as line 1, or
+	// the line of the iCalendar file the error is on.
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+type importResponse struct {
+	DryRun bool `json:"dryRun"`
+	// Events and Attendees count what is imported, or would be if the
+	// import had no errors.
+	Events    int              `json:"events"`
+	Attendees int              `json:"attendees"`
+	Errors    []importRowError `json:"errors"`
+	// Ids are the IDs of the created events, in the order of the file.
+	Ids []int `json:"ids,omitempty"`
+}
+
+// importRow is an event read from an imported file.
+type importRow struct {
+	row    int
+	event  *database.Event
+	emails []string
+
+	// source is the VEVENT the row was read from and overrides are the
+	// VEVENTs that change its occurrences, in iCalendar imports.
+	source    *ical.Event
+	overrides []*ical.Event
+}
+
+// importEvents imports events and their attendees from a file
+//
+// @Summary Imports events
+// @Description Creates events owned by the current user from a CSV or iCalendar file, sent as the file field of a multipart form or as the request body. The format follows the file extension or the content type, text/csv or text/calendar.
+// @Description
+// @Description CSV files start with a header row naming their columns, in any order: name, description, location, startsAt and endsAt are required; timeZone, capacity, rsvpPolicy, visibility, rrule, tags and attendees are optional. startsAt and endsAt are RFC 3339 times, or times such as 2027-03-01 18:00 read in timeZone, which defaults to UTC. tags are separated by semicolons or commas, and attendees lists email addresses separated by semicolons, commas or spaces.
+// @Description
+// @Description iCalendar files are read VEVENT by VEVENT: SUMMARY becomes the name, CATEGORIES the tags and ATTENDEE addresses the attendees. TZID parameters must name IANA time zones. Cancelled occurrences (EXDATE) and changed ones (RECURRENCE-ID) of recurring events become exceptions.
+// @Description
+// @Description Attendees are matched to users by email and can only be imported for one-off events. Every row is validated before anything is imported; if any row has errors, all of them are reported and nothing is imported. Otherwise everything is imported in one transaction, as drafts unless publish is set. With dryRun only the validation runs.
+// @Tags Events
+// @Accept multipart/form-data
+// @Accept text/csv
+// @Accept text/calendar
+// @Produce json
+// @Param file formData file false "CSV or iCalendar file"
+// @Param dryRun query bool false "Only validate the file" default(false)
+// @Param publish query bool false "Publish the imported events instead of keeping them as drafts" default(false)
+// @Success 200 {object} importResponse "Dry run without errors"
+// @Success 201 {object} importResponse
+// @Failure 400 {object} importResponse
+// @Security BearerAuth
+// @Router /api/v1/events/import [post]
+func (app *application) importEvents(c *gin.Context) {
+	dryRun := false
+	if value := c.Query("dryRun"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dryRun must be true or false"})
+			return
+		}
+	}
+
+	publish := false
+	if value := c.Query("publish"); value != "" {
+		var err error
+		if publish, err = strconv.ParseBool(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "publish must be true or false"})
+			return
+		}
+	}
+
+	var rows []*importRow
+	var rowErrors []importRowError
+
+	format, file, err := readImport(c)
+	if err == nil {
+		defer file.Close()
+
+		switch format {
+		case importCSV:
+			rows, rowErrors, err = readCSVImport(file)
+		case importICS:
+			rows, rowErrors, err = readICSImport(file)
+		default:
+			err = errors.New("file must be a .csv or .ics file")
+		}
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file must be at most %d MB", maxImportSize>>20)})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	imported, errs, err := app.validateImport(user, rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate import"})
+		return
+	}
+	rowErrors = append(rowErrors, errs...)
+	sort.SliceStable(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+
+	response := importResponse{DryRun: dryRun, Errors: []importRowError{}}
+	response.Errors = append(response.Errors, rowErrors...)
+	for _, event := range imported {
+		response.Events++
+		response.Attendees += len(event.AttendeeIds)
+	}
+
+	if len(response.Errors) > 0 {
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if publish {
+		for _, event := range imported {
+			event.Event.Status = database.EventPublished
+		}
+	}
+
+	if err := app.models.Events.Import(imported); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import events"})
+		return
+	}
+
+	response.Ids = make([]int, len(imported))
+	for i, event := range imported {
+		response.Ids[i] = event.Event.Id
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// readImport returns the format and contents of the imported file.
+func readImport(c *gin.Context) (string, io.ReadCloser, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	if c.ContentType() != "multipart/form-data" {
+		return importFormat("", c.ContentType()), c.Request.Body, nil
+	}
+
+	header, err := c.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return "", nil, err
+	}
+	if err != nil {
+		return "", nil, errors.New("file is required")
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return importFormat(path.Ext(header.Filename), header.Header.Get("Content-Type")), file, nil
+}
+
+func importFormat(ext, contentType string) string {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	switch {
+	case strings.EqualFold(ext, ".csv"), strings.EqualFold(contentType, "text/csv"):
+		return importCSV
+	case strings.EqualFold(ext, ".ics"), strings.EqualFold(contentType, "text/calendar"):
+		return importICS
+	}
+	return ""
+}
+
+// readCSVImport reads the events of a CSV import. Errors in the header are
+// returned as an error, errors in rows as row errors.
+func readCSVImport(file io.Reader) ([]*importRow, []importRowError, error) {
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		// Spreadsheets often start their CSV exports with a byte order mark.
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.TrimSpace(name)
+
+		column := ""
+		for _, c := range importColumns {
+			if strings.EqualFold(name, c) {
+				column = c
+			}
+		}
+		if column == "" {
+			return nil, nil, fmt.Errorf("unknown column %q; columns are %s", name, strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[column]; ok {
+			return nil, nil, fmt.Errorf("column %q appears more than once", column)
+		}
+		columns[column] = i
+	}
+
+	for _, column := range requiredImportColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, nil, fmt.Errorf("column %q is required", column)
+		}
+	}
+
+	var rows []*importRow
+	var rowErrors []importRowError
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, importRowError{Row: parseErr.StartLine, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := r.FieldPos(0)
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row, err := csvImportRow(value)
+		if err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: line, Error: err.Error()})
+			continue
+		}
+		row.row = line
+		rows = append(rows, row)
+	}
+
+	return rows, rowErrors, nil
+}
+
+// csvImportRow reads the event of a CSV record, given the values of its
+// columns.
+func csvImportRow(value func(column string) string) (*importRow, error) {
+	event := &database.Event{
+		Name:        value("name"),
+		Description: value("description"),
+		Location:    value("location"),
+		TimeZone:    value("timeZone"),
+		RSVPPolicy:  value("rsvpPolicy"),
+		Visibility:  value("visibility"),
+		RRule:       value("rrule"),
+	}
+
+	loc := time.UTC
+	if event.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(event.TimeZone); err != nil || strings.EqualFold(event.TimeZone, "local") {
+			return nil, errors.New("timeZone must be an IANA time zone such as Europe/Berlin")
+		}
+	}
+
+	var err error
+	if event.StartsAt, err = parseImportTime(value("startsAt"), loc); err != nil {
+		return nil, fmt.Errorf("startsAt %v", err)
+	}
+	if event.EndsAt, err = parseImportTime(value("endsAt"), loc); err != nil {
+		return nil, fmt.Errorf("endsAt %v", err)
+	}
+
+	if capacity := value("capacity"); capacity != "" {
+		n, err := strconv.Atoi(capacity)
+		if err != nil {
+			return nil, errors.New("capacity must be a number")
+		}
+		event.Capacity = &n
+	}
+
+	event.Tags = strings.FieldsFunc(value("tags"), func(r rune) bool {
+		return r == ';' || r == ','
+	})
+
+	emails := strings.FieldsFunc(value("attendees"), func(r rune) bool {
+		return r == ';' || r == ',' || unicode.IsSpace(r)
+	})
+
+	return &importRow{event: event, emails: emails}, nil
+}
+
+// parseImportTime parses an RFC 3339 time, or a time without an offset in
+// loc.
+func parseImportTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("is required")
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New("must be a time such as 2027-03-01 18:00 or 2027-03-01T18:00:00+01:00")
+}
+
+// readICSImport reads the events of an iCalendar import. VEVENTs that
+// change an occurrence of a recurring event are attached to the row of the
+// series.
+func readICSImport(file io.Reader) ([]*importRow, []importRowError, error) {
+	r := ical.NewReader(file)
+
+	var rows []*importRow
+	var rowErrors []importRowError
+	var overrides []*ical.Event
+	series := map[string]*importRow{}
+
+	for {
+		vevent, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *ical.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, importRowError{Row: parseErr.Line, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !vevent.RecurrenceId.IsZero() {
+			overrides = append(overrides, vevent)
+			continue
+		}
+
+		if vevent.Status == ical.StatusCancelled {
+			rowErrors = append(rowErrors, importRowError{Row: vevent.Line, Error: "event is cancelled"})
+			continue
+		}
+
+		if vevent.UID != "" {
+			if _, ok := series[vevent.UID]; ok {
+				rowErrors = append(rowErrors, importRowError{Row: vevent.Line, Error: fmt.Sprintf("UID %q is used by more than one event", vevent.UID)})
+				continue
+			}
+		}
+
+		row := &importRow{
+			row: vevent.Line,
+			event: &database.Event{
+				Name:        vevent.Summary,
+				Description: vevent.Description,
+				Location:    vevent.Location,
+				StartsAt:    vevent.Start,
+				EndsAt:      vevent.End,
+				TimeZone:    vevent.TimeZone,
+				RRule:       vevent.RRule,
+				Tags:        vevent.Categories,
+			},
+			emails: vevent.Attendees,
+			source: vevent,
+		}
+		rows = append(rows, row)
+
+		if vevent.UID != "" {
+			series[vevent.UID] = row
+		}
+	}
+
+	for _, override := range overrides {
+		row, ok := series[override.UID]
+		if !ok || row.source.RRule == "" {
+			rowErrors = append(rowErrors, importRowError{Row: override.Line, Error: fmt.Sprintf("no recurring event with UID %q to change", override.UID)})
+			continue
+		}
+		row.overrides = append(row.overrides, override)
+	}
+
+	return rows, rowErrors, nil
+}
+
+// validateImport validates the events read from an imported file for the
+// user to own and matches their attendees to users.
+func (app *application) validateImport(user *database.User, rows []*importRow) ([]*database.ImportedEvent, []importRowError, error) {
+	var imported []*database.ImportedEvent
+	var rowErrors []importRowError
+	users := map[string]*database.User{}
+
+	for _, row := range rows {
+		row.event.OwnerId = user.Id
+
+		if err := binding.Validator.ValidateStruct(row.event); err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row.row, Error: err.Error()})
+			continue
+		}
+
+		if err := validateTags(row.event); err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row.row, Error: err.Error()})
+			continue
+		}
+
+		if err := validateRecurrence(row.event); err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row.row, Error: err.Error()})
+			continue
+		}
+
+		event := &database.ImportedEvent{Event: row.event}
+
+		exceptions, errs := icsExceptions(row)
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			continue
+		}
+		event.Exceptions = exceptions
+
+		if len(row.emails) > 0 && row.event.RRule != "" {
+			rowErrors = append(rowErrors, importRowError{Row: row.row, Error: "attendees can only be imported for one-off events"})
+			continue
+		}
+
+		valid := true
+		seen := map[int]bool{}
+		for _, email := range row.emails {
+			attendee, ok := users[email]
+			if !ok {
+				var err error
+				if attendee, err = app.models.Users.GetByEmail(email); err != nil {
+					return nil, nil, err
+				}
+				users[email] = attendee
+			}
+
+			if attendee == nil {
+				rowErrors = append(rowErrors, importRowError{Row: row.row, Error: fmt.Sprintf("no user has the email %s", email)})
+				valid = false
+				continue
+			}
+
+			if !seen[attendee.Id] {
+				seen[attendee.Id] = true
+				event.AttendeeIds = append(event.AttendeeIds, attendee.Id)
+			}
+		}
+
+		if valid {
+			imported = append(imported, event)
+		}
+	}
+
+	return imported, rowErrors, nil
+}
+
+// icsExceptions turns the cancelled and changed occurrences of a recurring
+// event read from iCalendar into exceptions.
+func icsExceptions(row *importRow) ([]*database.EventException, []importRowError) {
+	if row.source == nil || row.event.RRule == "" {
+		return nil, nil
+	}
+
+	var exceptions []*database.EventException
+	var rowErrors []importRowError
+
+	for _, exdate := range row.source.ExDates {
+		key, ok := row.event.OccurrenceAt(exdate)
+		if !ok {
+			rowErrors = append(rowErrors, importRowError{Row: row.row, Error: fmt.Sprintf("EXDATE %s is not an occurrence of the event", exdate.UTC().Format(time.RFC3339))})
+			continue
+		}
+		exceptions = append(exceptions, &database.EventException{Occurrence: key, Cancelled: true})
+	}
+
+	for _, override := range row.overrides {
+		key, ok := row.event.OccurrenceAt(override.RecurrenceId)
+		if !ok {
+			rowErrors = append(rowErrors, importRowError{Row: override.Line, Error: "RECURRENCE-ID is not an occurrence of the event"})
+			continue
+		}
+
+		exception := &database.EventException{Occurrence: key, Cancelled: override.Status == ical.StatusCancelled}
+		if !exception.Cancelled {
+			if !override.End.After(override.Start) {
+				rowErrors = append(rowErrors, importRowError{Row: override.Line, Error: database.ErrInvalidTimes.Error()})
+				continue
+			}
+
+			exception.StartsAt, exception.EndsAt = &override.Start, &override.End
+			if override.Summary != "" && override.Summary != row.event.Name {
+				exception.Name = &override.Summary
+			}
+			if override.Description != "" && override.Description != row.event.Description {
+				exception.Description = &override.Description
+			}
+			if override.Location != "" && override.Location != row.event.Location {
+				exception.Location = &override.Location
+			}
+
+			if err := binding.Validator.ValidateStruct(exception); err != nil {
+				rowErrors = append(rowErrors, importRowError{Row: override.Line, Error: err.Error()})
+				continue
+			}
+		}
+
+		exceptions = append(exceptions, exception)
+	}
+
+	return exceptions, rowErrors
+}
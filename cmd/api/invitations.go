@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const tokenTypeInvitation = "invitation"
+
+type createInvitationRequest struct {
+	Email  string `json:"email" binding:"omitempty,email"`
+	UserId int    `json:"userId" binding:"omitempty,min=1"`
+}
+
+type invitationResponse struct {
+	*database.Invitation
+	Event *database.Event `json:"event"`
+}
+
+// invitationToken returns the token that answers invitation. It is signed
+// rather than stored, so it only needs to name the invitation; whether the
+// invitation can still be answered is checked in the database.
+func (app *application) invitationToken(invitation *database.Invitation) (string, error) {
+	return app.keys.sign(jwt.MapClaims{
+		"typ":          tokenTypeInvitation,
+		"invitationId": invitation.Id,
+		"iat":          invitation.CreatedAt.Unix(),
+		"exp":          invitation.ExpiresAt.Unix(),
+	})
+}
+
+// invitationFromToken loads the invitation named by the token in the URL. It
+// writes the error response and returns nil if there is none.
+func (app *application) invitationFromToken(c *gin.Context) *database.Invitation {
+	token, err := app.keys.parse(c.Param("token"))
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return nil
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	id, _ := claims["invitationId"].(float64)
+	if claims["typ"] != tokenTypeInvitation || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return nil
+	}
+
+	invitation, err := app.models.Invitations.Get(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return nil
+	}
+
+	if invitation == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+		return nil
+	}
+
+	return invitation
+}
+
+// eventForManagement loads the event in the URL for its owner or a
+// moderator. It writes the error response and returns nil otherwise.
+func (app *application) eventForManagement(c *gin.Context) *database.Event {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return nil
+	}
+
+	event, err := app.models.Events.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return nil
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return nil
+	}
+
+	if !app.canManageEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to manage this event"})
+		return nil
+	}
+
+	return event
+}
+
+// createInvitation invites somebody to an event
+//
+// @Summary Invites somebody to an event
+// @Description Emails an invitation to attend the event, either to an email address or to an existing user. The invitation links to a signed token that accepts or declines it until it expires. People without an account can register and then accept.
+// @Tags Invitations
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param request body createInvitationRequest true "Email address or user ID of the invitee"
+// @Success 201 {object} database.Invitation
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/invitations [post]
+func (app *application) createInvitation(c *gin.Context) {
+	var req createInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.Email == "") == (req.UserId == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give either an email address or a user ID"})
+		return
+	}
+
+	event := app.eventForManagement(c)
+	if event == nil {
+		return
+	}
+
+	if !checkOpen(c, event) {
+		return
+	}
+
+	invitation := &database.Invitation{
+		EventId:   event.Id,
+		InviterId: app.getUserFromContext(c).Id,
+		Email:     req.Email,
+	}
+
+	var invitee *database.User
+	var err error
+	if req.UserId != 0 {
+		invitee, err = app.models.Users.Get(req.UserId)
+		if err == nil && invitee == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if invitee != nil {
+			invitation.Email = invitee.Email
+			invitation.UserId = &invitee.Id
+		}
+	} else {
+		// Whoever registered an address need not own it, so only a verified
+		// account is bound to the invitation; otherwise the address has to
+		// be verified on acceptance.
+		invitee, err = app.models.Users.GetByEmail(req.Email)
+		if invitee != nil && invitee.VerifiedAt == nil {
+			invitee = nil
+		}
+		if invitee != nil {
+			invitation.UserId = &invitee.Id
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	// Invitations to a recurring event are to the series, so attending one
+	// of its occurrences is no reason to refuse one.
+	if invitee != nil && event.RRule == "" {
+		attendee, err := app.models.Attendees.GetByEventAndAttendee(event.Id, "", invitee.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if attendee != nil && attendee.Status != database.AttendeePending && attendee.RSVP != database.RSVPDeclined {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already attending"})
+			return
+		}
+	}
+
+	pending, err := app.models.Invitations.HasPending(event.Id, invitation.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if pending {
+		c.JSON(http.StatusConflict, gin.H{"error": "An invitation for this address is already pending"})
+		return
+	}
+
+	now := time.Now().UTC()
+	invitation.CreatedAt = now
+	invitation.ExpiresAt = now.Add(app.invitationTTL)
+
+	if err := app.models.Invitations.Insert(invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	token, err := app.invitationToken(invitation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	greeting := "Hi"
+	if invitee != nil {
+		greeting = "Hi " + invitee.Name
+	}
+
+	app.sendMail(&mailer.Message{
+		To:      invitation.Email,
+		Subject: "You are invited to " + event.Name,
+		Body: fmt.Sprintf("%s,\n\n%s invited you to %s on %s in %s.\n\nSee the invitation and answer it here:\n\n%s/api/v1/invitations/%s\n\nTo accept you need an account with this address, verified. The invitation expires on %s.\n",
+			greeting, app.getUserFromContext(c).Name, event.Name, formatEventTime(event, event.StartsAt), event.Location,
+			app.baseURL, token, invitation.ExpiresAt.Format("January 2, 2006 15:04 MST")),
+	})
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// getEventInvitations lists the pending invitations of an event
+//
+// @Summary Returns the pending invitations of an event
+// @Description Returns the invitations of the event that were neither answered nor revoked and have not expired, oldest first.
+// @Tags Invitations
+// @Produce json
+// @Param id path int true "Event ID"
+// @Success 200 {object} []database.Invitation
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/invitations [get]
+func (app *application) getEventInvitations(c *gin.Context) {
+	event := app.eventForManagement(c)
+	if event == nil {
+		return
+	}
+
+	invitations, err := app.models.Invitations.GetPendingByEvent(event.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// revokeInvitation withdraws a pending invitation
+//
+// @Summary Revokes an invitation
+// @Description Withdraws a pending invitation so it can no longer be answered.
+// @Tags Invitations
+// @Param id path int true "Event ID"
+// @Param invitationId path int true "Invitation ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/invitations/{invitationId} [delete]
+func (app *application) revokeInvitation(c *gin.Context) {
+	invitationId, err := strconv.Atoi(c.Param("invitationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+		return
+	}
+
+	event := app.eventForManagement(c)
+	if event == nil {
+		return
+	}
+
+	invitation, err := app.models.Invitations.Get(invitationId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if invitation == nil || invitation.EventId != event.Id {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+
+	revoked, err := app.models.Invitations.Revoke(invitation.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+		return
+	}
+
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation is no longer pending"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// getInvitation shows an invitation
+//
+// @Summary Returns an invitation
+// @Description Returns the invitation a token belongs to, together with its event.
+// @Tags Invitations
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Success 200 {object} invitationResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/invitations/{token} [get]
+func (app *application) getInvitation(c *gin.Context) {
+	invitation := app.invitationFromToken(c)
+	if invitation == nil {
+		return
+	}
+
+	event, err := app.models.Events.Get(invitation.EventId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	event.In(nil)
+	c.JSON(http.StatusOK, invitationResponse{Invitation: invitation, Event: event})
+}
+
+// acceptInvitation accepts an invitation as the current user
+//
+// @Summary Accepts an invitation
+// @Description Makes the authenticated user an attendee who is going, regardless of the event's RSVP policy; a full event waitlists them. An invitation made for a particular user, or for the email address of one, can only be accepted by that user; one for another address only by an account that has verified that address. For a recurring event the user attends the given occurrence, or the next one that is not cancelled, and can sign up for the others afterwards.
+// @Tags Invitations
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Param occurrence query string false "Occurrence date of a recurring event, YYYY-MM-DD"
+// @Success 200 {object} database.Attendee
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/invitations/{token}/accept [post]
+func (app *application) acceptInvitation(c *gin.Context) {
+	invitation := app.invitationFromToken(c)
+	if invitation == nil {
+		return
+	}
+
+	user := app.getUserFromContext(c)
+	if invitation.UserId != nil && *invitation.UserId != user.Id {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invitation is for another account"})
+		return
+	}
+
+	// Anybody the link is forwarded to could accept an invitation made for
+	// an address without an account, so it takes that address, verified.
+	if invitation.UserId == nil && (user.VerifiedAt == nil || !strings.EqualFold(user.Email, invitation.Email)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This invitation is for another email address; accept it from an account with that address, verified"})
+		return
+	}
+
+	if !invitation.Open() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation was already answered or has expired"})
+		return
+	}
+
+	event, err := app.models.Events.Get(invitation.EventId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	if !checkOpen(c, event) {
+		return
+	}
+
+	occurrence := ""
+	if event.RRule != "" && c.Query("occurrence") == "" {
+		occurrence, err = app.nextOccurrence(event)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if occurrence == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Event has no upcoming occurrences"})
+			return
+		}
+	} else {
+		var ok bool
+		if occurrence, ok = app.occurrenceParam(c, event, false); !ok {
+			return
+		}
+	}
+
+	attendee, promoted, err := app.models.Invitations.Accept(invitation, occurrence, user.Id)
+	if errors.Is(err, database.ErrInvitationClosed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation was already answered or has expired"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept invitation"})
+		return
+	}
+
+	app.notifyPromoted(event, promoted)
+
+	c.JSON(http.StatusOK, attendee)
+}
+
+// declineInvitation declines an invitation
+//
+// @Summary Declines an invitation
+// @Description Declines the invitation. No account is needed, the token is enough.
+// @Tags Invitations
+// @Produce json
+// @Param token path string true "Invitation token"
+// @Success 200 {object} database.Invitation
+// @Failure 400 {object} map[string]string
+// @Router /api/v1/invitations/{token}/decline [post]
+func (app *application) declineInvitation(c *gin.Context) {
+	invitation := app.invitationFromToken(c)
+	if invitation == nil {
+		return
+	}
+
+	if !invitation.Open() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation was already answered or has expired"})
+		return
+	}
+
+	err := app.models.Invitations.Decline(invitation)
+	if errors.Is(err, database.ErrInvitationClosed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invitation was already answered or has expired"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decline invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
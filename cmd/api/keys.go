@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// insecureDefaultSecret is the secret older versions fell back to when
+// JWT_SECRET was unset. Tokens signed with it must never be accepted.
+const insecureDefaultSecret = "some-secret-123456"
+
+// signingKey is a key that can verify tokens and, if it holds the private
+// half, sign them.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+	secret  []byte
+}
+
+// keySet holds the key new tokens are signed with and every key tokens are
+// still accepted from. Keeping the previous public keys around lets the
+// signing key be rotated without logging everybody out.
+type keySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	hmac    *signingKey
+}
+
+// jsonWebKey is the public part of a signing key as published in the JWKS.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// loadKeySet builds the key set from a PEM private key, any number of PEM
+// public keys of retired signing keys and an optional HMAC secret. The
+// private key signs new tokens; without one the secret is used instead.
+func loadKeySet(privateKeyFile string, publicKeyFiles []string, secret string) (*keySet, error) {
+	ks := &keySet{keys: make(map[string]*signingKey)}
+
+	if secret != "" {
+		if secret == insecureDefaultSecret {
+			return nil, errors.New("JWT_SECRET is set to the old default value, configure a real secret")
+		}
+		if len(secret) < 32 {
+			return nil, errors.New("JWT_SECRET must be at least 32 characters long")
+		}
+		ks.hmac = &signingKey{method: jwt.SigningMethodHS256, secret: []byte(secret)}
+	}
+
+	if privateKeyFile != "" {
+		key, err := readPrivateKey(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ks.signing = key
+		ks.keys[key.id] = key
+	} else if ks.hmac != nil {
+		ks.signing = ks.hmac
+	} else {
+		return nil, errors.New("no signing key configured, set JWT_PRIVATE_KEY_FILE or JWT_SECRET")
+	}
+
+	for _, file := range publicKeyFiles {
+		key, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := ks.keys[key.id]; !exists {
+			ks.keys[key.id] = key
+		}
+	}
+
+	return ks, nil
+}
+
+func readPrivateKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return newSigningKey(jwt.SigningMethodRS256, key, &key.PublicKey)
+	}
+
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		if edKey, ok := key.(ed25519.PrivateKey); ok {
+			return newSigningKey(jwt.SigningMethodEdDSA, edKey, edKey.Public())
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not a PEM encoded RSA or Ed25519 private key", file)
+}
+
+func readPublicKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %w", err)
+	}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return newSigningKey(jwt.SigningMethodRS256, nil, key)
+	}
+
+	if key, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return newSigningKey(jwt.SigningMethodEdDSA, nil, key)
+	}
+
+	return nil, fmt.Errorf("%s is not a PEM encoded RSA or Ed25519 public key", file)
+}
+
+func newSigningKey(method jwt.SigningMethod, private crypto.PrivateKey, public crypto.PublicKey) (*signingKey, error) {
+	key := &signingKey{method: method, private: private, public: public}
+
+	jwk, err := key.jwk()
+	if err != nil {
+		return nil, err
+	}
+	key.id = jwk.Kid
+
+	return key, nil
+}
+
+// jwk returns the public key in JWK form. The kid is the RFC 7638
+// thumbprint, so every instance loading the same key agrees on it.
+func (k *signingKey) jwk() (*jsonWebKey, error) {
+	var jwk *jsonWebKey
+	var thumbprintInput string
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		n := base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		jwk = &jsonWebKey{Kty: "RSA", N: n, E: e}
+		thumbprintInput = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, e, n)
+	case ed25519.PublicKey:
+		x := base64.RawURLEncoding.EncodeToString(pub)
+		jwk = &jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: x}
+		thumbprintInput = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, x)
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+
+	sum := sha256.Sum256([]byte(thumbprintInput))
+	jwk.Kid = base64.RawURLEncoding.EncodeToString(sum[:])
+	jwk.Use = "sig"
+	jwk.Alg = k.method.Alg()
+
+	return jwk, nil
+}
+
+// sign signs claims with the current signing key.
+func (ks *keySet) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	if ks.signing.id != "" {
+		token.Header["kid"] = ks.signing.id
+	}
+
+	if ks.signing.secret != nil {
+		return token.SignedString(ks.signing.secret)
+	}
+	return token.SignedString(ks.signing.private)
+}
+
+// parse verifies tokenString against the key named by its kid header, or
+// the HMAC secret for tokens without one.
+func (ks *keySet) parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		key := ks.hmac
+		if kid, ok := token.Header["kid"].(string); ok {
+			key = ks.keys[kid]
+		}
+
+		if key == nil || token.Method.Alg() != key.method.Alg() {
+			return nil, jwt.ErrSignatureInvalid
+		}
+
+		if key.secret != nil {
+			return key.secret, nil
+		}
+		return key.public, nil
+	})
+}
+
+// jwks returns the public keys that tokens are currently accepted from.
+func (ks *keySet) jwks() ([]*jsonWebKey, error) {
+	jwks := []*jsonWebKey{}
+	for _, key := range ks.keys {
+		jwk, err := key.jwk()
+		if err != nil {
+			return nil, err
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks, nil
+}
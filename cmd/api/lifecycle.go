@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listedStatuses are the statuses listings show by default: every one but
+// drafts.
+var listedStatuses = []string{database.EventPublished, database.EventCancelled, database.EventCompleted}
+
+type cancelEventRequest struct {
+	Reason string `json:"reason" binding:"required,min=3,max=500"`
+}
+
+// parseStatusFilter reads the status parameter into the filter, defaulting
+// to listedStatuses.
+func parseStatusFilter(c *gin.Context, filter *database.EventFilter) error {
+	statuses := c.Query("status")
+	if statuses == "" {
+		filter.Statuses = listedStatuses
+		return nil
+	}
+
+	for _, status := range strings.Split(statuses, ",") {
+		status = strings.TrimSpace(status)
+		switch status {
+		case database.EventDraft, database.EventPublished, database.EventCancelled, database.EventCompleted:
+			filter.Statuses = append(filter.Statuses, status)
+		default:
+			return errors.New("status must be out of draft, published, cancelled and completed")
+		}
+	}
+	return nil
+}
+
+// checkOpen answers 409 if the event was cancelled or completed, which
+// freezes it. It reports whether the request may go on.
+func checkOpen(c *gin.Context, event *database.Event) bool {
+	if !event.Open() {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Event is %s", event.Status)})
+		return false
+	}
+	return true
+}
+
+// publishEvent publishes a draft
+//
+// @Summary Publishes an event
+// @Description Publishes a draft, which makes it visible as its visibility allows and lets people sign up. Only drafts can be published.
+// @Tags Events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of the event"
+// @Success 200 {object} database.Event
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/publish [post]
+func (app *application) publishEvent(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	event := app.eventForManagement(c)
+	if event == nil {
+		return
+	}
+
+	if err := app.models.Events.SetStatus(event, database.EventPublished, ""); err != nil {
+		if errors.Is(err, database.ErrInvalidTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only drafts can be published"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish event"})
+		return
+	}
+
+	event.In(loc)
+	c.JSON(http.StatusOK, event)
+}
+
+// cancelEvent cancels a published event
+//
+// @Summary Cancels an event
+// @Description Cancels a published event for the given reason, which attendees are emailed. Attendees are kept, but nobody can sign up any more and the event cannot be changed. Calendar apps see the event as cancelled. Only published events can be cancelled; drafts are deleted instead.
+// @Tags Events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param request body cancelEventRequest true "Why the event is cancelled"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of the event"
+// @Success 200 {object} database.Event
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/cancel [post]
+func (app *application) cancelEvent(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	var req cancelEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event := app.eventForManagement(c)
+	if event == nil {
+		return
+	}
+
+	if err := app.models.Events.SetStatus(event, database.EventCancelled, req.Reason); err != nil {
+		if errors.Is(err, database.ErrInvalidTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only published events can be cancelled"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel event"})
+		return
+	}
+
+	app.notifyCancelled(event)
+
+	event.In(loc)
+	c.JSON(http.StatusOK, event)
+}
+
+// notifyCancelled tells the attendees of a cancelled event, other than
+// those who declined, that it will not take place.
+func (app *application) notifyCancelled(event *database.Event) {
+	users, err := app.models.Attendees.GetUsersByEvent(event.Id)
+	if err != nil {
+		log.Printf("failed to notify attendees of cancelled event %d: %v", event.Id, err)
+		return
+	}
+
+	for _, user := range users {
+		app.sendMail(&mailer.Message{
+			To:      user.Email,
+			Subject: event.Name + " is cancelled",
+			Body: fmt.Sprintf("Hi %s,\n\n%s on %s has been cancelled:\n\n%s\n",
+				user.Name, event.Name, formatEventTime(event, event.StartsAt), event.CancellationReason),
+		})
+	}
+}
+
+// completeEndedEvents marks published events completed once they are over.
+// It runs until the process exits.
+func (app *application) completeEndedEvents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		completed, err := app.models.Events.CompleteEnded(time.Now())
+		if err != nil {
+			log.Printf("failed to complete ended events: %v", err)
+		} else if completed > 0 {
+			log.Printf("completed %d ended events", completed)
+		}
+
+		<-ticker.C
+	}
+}
+
+// restoreEvent undeletes an event
+//
+// @Summary Restores a deleted event
+// @Description Undeletes an event, attendees included, as it was when it was deleted. Deleted events can be restored for EVENT_RETENTION_DAYS days, after which they are purged for good.
+// @Tags Events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of the event"
+// @Success 200 {object} database.Event
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 410 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/restore [post]
+func (app *application) restoreEvent(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	event, err := app.models.Events.GetDeleted(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		live, err := app.models.Events.Get(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+			return
+		}
+		if live != nil && app.canManageEvent(c, live) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Event is not deleted"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	if !app.canManageEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to manage this event"})
+		return
+	}
+
+	restored, err := app.models.Events.Restore(event, time.Now().Add(-app.eventRetention))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore event"})
+		return
+	}
+
+	if !restored {
+		c.JSON(http.StatusGone, gin.H{"error": "Event was deleted too long ago to be restored"})
+		return
+	}
+
+	event.In(loc)
+	c.JSON(http.StatusOK, event)
+}
+
+// purgeDeletedEvents removes events deleted longer than the retention
+// period ago for good. It runs until the process exits.
+func (app *application) purgeDeletedEvents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.models.Events.PurgeDeleted(time.Now().Add(-app.eventRetention))
+		if err != nil {
+			log.Printf("failed to purge deleted events: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d deleted events", purged)
+		}
+
+		<-ticker.C
+	}
+}
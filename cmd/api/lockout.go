@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginAttemptStore keeps failed login counters. memoryAttemptStore serves a
+// single node; database.LoginAttemptModel keeps the counters in SQLite so
+// they survive restarts.
+type loginAttemptStore interface {
+	Get(key string) (*database.LoginAttempt, error)
+	RecordFailure(key string, window time.Duration) (*database.LoginAttempt, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+type memoryAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]*database.LoginAttempt
+	lastPrune time.Time
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{attempts: make(map[string]*database.LoginAttempt)}
+}
+
+func (s *memoryAttemptStore) Get(key string) (*database.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *memoryAttemptStore) RecordFailure(key string, window time.Duration) (*database.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.prune(now, window)
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = &database.LoginAttempt{Key: key}
+		s.attempts[key] = attempt
+	}
+
+	if attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+
+	copied := *attempt
+	return &copied, nil
+}
+
+func (s *memoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		until = until.UTC()
+		attempt.LockedUntil = &until
+	}
+	return nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// prune drops counters that can no longer block anybody. It runs at most
+// once a minute so a flood of failures does not make every call O(n).
+func (s *memoryAttemptStore) prune(now time.Time, window time.Duration) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+
+	for key, attempt := range s.attempts {
+		locked := attempt.LockedUntil != nil && attempt.LockedUntil.After(now)
+		if !locked && attempt.LastFailureAt.Before(now.Add(-window)) {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// loginPolicy decides when failed logins slow down or lock out further
+// attempts. Counters are kept per email address and per client IP; the IP
+// limit is higher because many users can share an address.
+type loginPolicy struct {
+	maxFailures   int
+	maxIPFailures int
+	window        time.Duration
+	lockout       time.Duration
+}
+
+func loginEmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginKeys(c *gin.Context, email string) []string {
+	return []string{loginEmailKey(email), "ip:" + c.ClientIP()}
+}
+
+func (p loginPolicy) limit(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return p.maxIPFailures
+	}
+	return p.maxFailures
+}
+
+// delay is how long a client has to wait after its latest failure. It
+// doubles with every failure past the second, up to 30 seconds.
+func (p loginPolicy) delay(failures int) time.Duration {
+	if failures < 2 {
+		return 0
+	}
+	d := time.Duration(math.Pow(2, float64(failures-2))) * time.Second
+	if d > 30*time.Second {
+		d = 30 * time.Second
+	}
+	return d
+}
+
+// checkLoginAllowed aborts the request with 429 if any of the keys is locked
+// out or still inside its progressive delay. It reports whether the login
+// may go ahead.
+func (app *application) checkLoginAllowed(c *gin.Context, keys []string) bool {
+	now := time.Now()
+	var wait time.Duration
+
+	for _, key := range keys {
+		attempt, err := app.loginAttempts.Get(key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return false
+		}
+
+		if attempt == nil {
+			continue
+		}
+
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			if d := attempt.LockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+			continue
+		}
+
+		if attempt.LastFailureAt.Before(now.Add(-app.loginPolicy.window)) {
+			continue
+		}
+
+		if d := attempt.LastFailureAt.Add(app.loginPolicy.delay(attempt.Failures)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+		return false
+	}
+
+	return true
+}
+
+// recordLoginFailure counts a failed login against every key and locks the
+// ones that reached their limit.
+func (app *application) recordLoginFailure(c *gin.Context, keys []string) {
+	for _, key := range keys {
+		attempt, err := app.loginAttempts.RecordFailure(key, app.loginPolicy.window)
+		if err != nil {
+			log.Printf("failed to record login failure: %v", err)
+			continue
+		}
+
+		if attempt.Failures < app.loginPolicy.limit(key) {
+			continue
+		}
+
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(time.Now()) {
+			continue
+		}
+
+		until := time.Now().Add(app.loginPolicy.lockout)
+		if err := app.loginAttempts.Lock(key, until); err != nil {
+			log.Printf("failed to lock %s: %v", key, err)
+			continue
+		}
+
+		app.audit(c, nil, "auth.lockout", key, fmt.Sprintf("%d failed logins, locked until %s", attempt.Failures, until.UTC().Format(time.RFC3339)))
+	}
+}
+
+// resetLoginFailures clears the counter of an email address after a
+// successful login. The IP counter is left alone so one valid account cannot
+// be used to keep resetting it.
+func (app *application) resetLoginFailures(email string) {
+	if err := app.loginAttempts.Reset(loginEmailKey(email)); err != nil {
+		log.Printf("failed to reset login failures: %v", err)
+	}
+}
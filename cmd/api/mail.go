@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"log"
+	"rest-go-gin/internal/mailer"
+	"time"
+)
+
+// sendMail delivers msg in the background so handlers neither wait for the
+// mail server nor reveal through their timing whether a message was sent.
+func (app *application) sendMail(msg *mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := app.mailer.Send(ctx, msg); err != nil {
+			log.Printf("failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"os"
 	_ "rest-api-in-gin/docs"
 	"rest-go-gin/internal/database"
 	"rest-go-gin/internal/env"
+	"rest-go-gin/internal/mailer"
+	"time"
+	// Embedded so event time zones resolve without system zone data.
+	_ "time/tzdata"
 
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/mattn/go-sqlite3"
@@ -18,16 +24,37 @@ import (
 // @in header
 // @name Authorization
 // @desctription Enter your bearer token in the format **Bearer &lt;token&git;**
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description Personal API key created at /api/v1/me/api-keys
 
 type application struct {
 	port int
-	jwtSecret string
+	keys *keySet
+	accessTokenTTL time.Duration
+	refreshTokenTTL time.Duration
+	passwordResetTTL time.Duration
+	verificationTTL time.Duration
+	invitationTTL time.Duration
+	requireVerifiedEmail bool
+	mfaIssuer string
+	baseURL string
 	models database.Models
+	revocations *revocationStore
+	mailer mailer.Mailer
+	loginAttempts loginAttemptStore
+	loginPolicy loginPolicy
+	accountDeletionGrace time.Duration
+	eventRetention time.Duration
+	oidc *oidcProvider
 }
 
 func main() {
 
-	db, err := sql.Open("sqlite3", "./data.db")
+	// Transactions take the write lock up front, so read-then-write
+	// transactions such as waitlist promotion cannot interleave.
+	db, err := sql.Open("sqlite3", "./data.db?_foreign_keys=on&_txlock=immediate")
 
 	if err != nil {
 		log.Fatal(err)
@@ -35,15 +62,108 @@ func main() {
 
 	defer db.Close()
 
+	if err := database.CheckFTS5(db); err != nil {
+		log.Fatal(err)
+	}
+
 	models := database.NewModels(db)
+	revocations, err := newRevocationStore(&models.Revocations)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err := loadKeySet(
+		env.GetEnvString("JWT_PRIVATE_KEY_FILE", ""),
+		env.GetEnvStringSlice("JWT_PUBLIC_KEY_FILES", nil),
+		env.GetEnvString("JWT_SECRET", ""),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mail, err := newMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	baseURL := env.GetEnvString("APP_BASE_URL", "http://localhost:8080")
+
+	oidcProvider, err := newOIDCProvider(
+		env.GetEnvString("OIDC_ISSUER", ""),
+		env.GetEnvString("OIDC_CLIENT_ID", ""),
+		env.GetEnvString("OIDC_CLIENT_SECRET", ""),
+		env.GetEnvString("OIDC_REDIRECT_URL", baseURL+"/api/v1/auth/oidc/callback"),
+		env.GetEnvStringSlice("OIDC_SCOPES", []string{"email", "profile"}),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var loginAttempts loginAttemptStore
+	switch store := env.GetEnvString("LOGIN_ATTEMPT_STORE", "memory"); store {
+	case "memory":
+		loginAttempts = newMemoryAttemptStore()
+	case "sqlite":
+		loginAttempts = &models.LoginAttempts
+	default:
+		log.Fatalf("unknown LOGIN_ATTEMPT_STORE %q, use memory or sqlite", store)
+	}
+
 	app := &application{
 		port: env.GetEnvInt("PORT",8080),
-		jwtSecret: env.GetEnvString("JWT_SECRET","some-secret-123456"),
+		keys: keys,
+		accessTokenTTL: time.Duration(env.GetEnvInt("ACCESS_TOKEN_TTL_MINUTES", 15)) * time.Minute,
+		refreshTokenTTL: time.Duration(env.GetEnvInt("REFRESH_TOKEN_TTL_HOURS", 30*24)) * time.Hour,
+		passwordResetTTL: time.Duration(env.GetEnvInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute,
+		verificationTTL: time.Duration(env.GetEnvInt("VERIFICATION_TTL_HOURS", 48)) * time.Hour,
+		invitationTTL: time.Duration(env.GetEnvInt("INVITATION_TTL_HOURS", 7*24)) * time.Hour,
+		requireVerifiedEmail: env.GetEnvBool("REQUIRE_VERIFIED_EMAIL", false),
+		mfaIssuer: env.GetEnvString("MFA_ISSUER", "Events API"),
+		baseURL: baseURL,
 		models: models,
+		revocations: revocations,
+		mailer: mail,
+		loginAttempts: loginAttempts,
+		loginPolicy: loginPolicy{
+			maxFailures:   env.GetEnvInt("LOGIN_MAX_FAILURES", 5),
+			maxIPFailures: env.GetEnvInt("LOGIN_MAX_IP_FAILURES", 20),
+			window:        time.Duration(env.GetEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+			lockout:       time.Duration(env.GetEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		},
+		accountDeletionGrace: time.Duration(env.GetEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 14)) * 24 * time.Hour,
+		eventRetention: time.Duration(env.GetEnvInt("EVENT_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		oidc: oidcProvider,
 	}
 
+	go app.purgeDeletedAccounts(time.Hour)
+	go app.completeEndedEvents(time.Hour)
+	go app.purgeDeletedEvents(time.Hour)
+
 	if err := app.serve(); err != nil {
 		log.Fatal(err)
 	}
 
 }
+
+// newMailer returns the mailer selected by MAILER: "stdout" (the default)
+// prints messages, "file" stores them in MAIL_DIR and "smtp" sends them.
+func newMailer() (mailer.Mailer, error) {
+	from := env.GetEnvString("MAIL_FROM", "Events <no-reply@localhost>")
+
+	switch kind := env.GetEnvString("MAILER", "stdout"); kind {
+	case "stdout":
+		return &mailer.WriterMailer{From: from, W: os.Stdout}, nil
+	case "file":
+		return &mailer.FileMailer{From: from, Dir: env.GetEnvString("MAIL_DIR", "./tmp/mail")}, nil
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Host:     env.GetEnvString("SMTP_HOST", "localhost"),
+			Port:     env.GetEnvInt("SMTP_PORT", 1025),
+			Username: env.GetEnvString("SMTP_USERNAME", ""),
+			Password: env.GetEnvString("SMTP_PASSWORD", ""),
+			From:     from,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, use stdout, file or smtp", kind)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type meResponse struct {
+	*database.User
+	// PendingEmail is the new address of an email change that still waits
+	// for verification.
+	PendingEmail string `json:"pendingEmail,omitempty"`
+}
+
+type updateMeRequest struct {
+	Name  *string `json:"name" binding:"omitempty,min=2"`
+	Email *string `json:"email" binding:"omitempty,email"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,min=8"`
+}
+
+type deleteMeRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func (app *application) meResponse(user *database.User) (*meResponse, error) {
+	pending, err := app.models.Verifications.GetPendingEmail(user.Id, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &meResponse{User: user, PendingEmail: pending}, nil
+}
+
+// getMe returns the current user
+//
+// @Summary Returns the current user
+// @Description Returns the account of the authenticated user, including an email change that still waits for verification.
+// @Tags Me
+// @Produce json
+// @Success 200 {object} meResponse
+// @Security BearerAuth
+// @Router /api/v1/me [get]
+func (app *application) getMe(c *gin.Context) {
+	response, err := app.meResponse(app.getUserFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// updateMe changes the current user's name or email
+//
+// @Summary Updates the current user
+// @Description Changes the name right away. A new email address only replaces the current one after it has been confirmed through the link sent to it.
+// @Tags Me
+// @Accept json
+// @Produce json
+// @Param request body updateMeRequest true "Fields to change"
+// @Success 200 {object} meResponse
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me [patch]
+func (app *application) updateMe(c *gin.Context) {
+	var req updateMeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	if req.Name != nil && *req.Name != user.Name {
+		if err := app.models.Users.UpdateName(user.Id, *req.Name); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+		user.Name = *req.Name
+	}
+
+	if req.Email != nil && !strings.EqualFold(*req.Email, user.Email) {
+		other, err := app.models.Users.GetByEmail(*req.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if other != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email address is already in use"})
+			return
+		}
+
+		if err := app.sendVerificationEmail(user, *req.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification email"})
+			return
+		}
+
+		app.sendMail(&mailer.Message{
+			To:      user.Email,
+			Subject: "Your email address is being changed",
+			Body: fmt.Sprintf("Hi %s,\n\nSomebody asked to change the email address of your account to %s. The change takes effect once the new address is confirmed. If this was not you, change your password right away.\n",
+				user.Name, *req.Email),
+		})
+	}
+
+	response, err := app.meResponse(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// changePassword changes the current user's password
+//
+// @Summary Changes the password
+// @Description Sets a new password after checking the current one. Every other session is ended and a new session is returned.
+// @Tags Me
+// @Accept json
+// @Produce json
+// @Param request body changePasswordRequest true "Current and new password"
+// @Success 200 {object} loginResponse
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me/password [post]
+func (app *application) changePassword(c *gin.Context) {
+	var req changePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	keys := loginKeys(c, user.Email)
+	if !app.checkLoginAllowed(c, keys) {
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		app.recordLoginFailure(c, keys)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.models.Users.UpdatePassword(user.Id, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	if err := app.models.PasswordResets.InvalidateForUser(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.revokeAllSessions(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	app.audit(c, user, "account.password_changed", "user:"+strconv.Itoa(user.Id), "")
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nThe password of your account was just changed and all other sessions were signed out. If this was not you, reset your password right away.\n", user.Name),
+	})
+
+	tokens, err := app.issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// deleteMe schedules the current user's account for deletion
+//
+// @Summary Deletes the current user
+// @Description Schedules the account for deletion after a grace period and ends every session. Logging in again before the period ends cancels the deletion.
+// @Tags Me
+// @Accept json
+// @Produce json
+// @Param request body deleteMeRequest true "Current password"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/me [delete]
+func (app *application) deleteMe(c *gin.Context) {
+	var req deleteMeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	keys := loginKeys(c, user.Email)
+	if !app.checkLoginAllowed(c, keys) {
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		app.recordLoginFailure(c, keys)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	deleteAt := time.Now().Add(app.accountDeletionGrace).UTC()
+	if err := app.models.Users.ScheduleDeletion(user.Id, deleteAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if err := app.revokeAllSessions(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	app.promoteIntoSeatsOf(user.Id)
+
+	app.audit(c, user, "account.deletion_scheduled", "user:"+strconv.Itoa(user.Id), deleteAt.Format(time.RFC3339))
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account and all its events will be deleted on %s. To keep it, simply log in again before then.\n",
+			user.Name, deleteAt.Format("January 2, 2006 15:04 MST")),
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":             "Account scheduled for deletion",
+		"deletionScheduledAt": deleteAt,
+	})
+}
+
+// getMyEvents returns the events of the current user
+//
+// @Summary Returns the current user's events
+// @Description Returns the events owned by the authenticated user.
+// @Tags Me
+// @Produce json
+// @Param tz query string false "IANA time zone to render times in; by default the zone of each event"
+// @Success 200 {object} []database.Event
+// @Security BearerAuth
+// @Router /api/v1/me/events [get]
+func (app *application) getMyEvents(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	events, err := app.models.Events.GetAllByOwner(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	eventsIn(loc, events)
+	c.JSON(http.StatusOK, events)
+}
+
+// getMyAttendance returns the events the current user attends
+//
+// @Summary Returns the events the current user attends
+// @Description Returns every event the authenticated user is an attendee of, except drafts, which are not open to attendees yet.
+// @Tags Me
+// @Produce json
+// @Param tz query string false "IANA time zone to render times in; by default the zone of each event"
+// @Success 200 {object} []database.Event
+// @Security BearerAuth
+// @Router /api/v1/me/attending [get]
+func (app *application) getMyAttendance(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	events, err := app.models.Attendees.GetEventsByAttendee(user.Id, database.EventFilter{Statuses: listedStatuses})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	eventsIn(loc, events)
+	c.JSON(http.StatusOK, events)
+}
+
+// promoteIntoSeatsOf gives the seats of a user whose account waits to be
+// deleted to the waitlisted attendees next in line, and tells them.
+func (app *application) promoteIntoSeatsOf(userId int) {
+	promoted, err := app.models.Attendees.PromoteIntoSeatsOf(userId)
+	if err != nil {
+		log.Printf("failed to promote into the seats of user %d: %v", userId, err)
+		return
+	}
+
+	byEvent := map[int][]*database.Attendee{}
+	for _, attendee := range promoted {
+		byEvent[attendee.EventId] = append(byEvent[attendee.EventId], attendee)
+	}
+
+	for eventId, attendees := range byEvent {
+		event, err := app.models.Events.Get(eventId)
+		if err != nil || event == nil {
+			log.Printf("failed to notify attendees promoted in event %d: %v", eventId, err)
+			continue
+		}
+		app.notifyPromoted(event, attendees)
+	}
+}
+
+// loginSucceeded runs once a user has passed every login factor. Logging in
+// also cancels a scheduled account deletion.
+func (app *application) loginSucceeded(c *gin.Context, user *database.User) {
+	app.resetLoginFailures(user.Email)
+
+	if user.DeletionScheduledAt == nil {
+		return
+	}
+
+	if err := app.models.Users.Restore(user.Id); err != nil {
+		log.Printf("failed to cancel deletion of user %d: %v", user.Id, err)
+		return
+	}
+	user.DeletionScheduledAt = nil
+
+	app.audit(c, user, "account.deletion_cancelled", "user:"+strconv.Itoa(user.Id), "")
+}
+
+// purgeDeletedAccounts removes accounts whose deletion grace period has
+// ended. It runs until the process exits.
+func (app *application) purgeDeletedAccounts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := app.models.Users.DeleteScheduled(time.Now())
+		if err != nil {
+			log.Printf("failed to purge deleted accounts: %v", err)
+		} else if deleted > 0 {
+			log.Printf("purged %d deleted accounts", deleted)
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/totp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	tokenTypeAccess = "access"
+	tokenTypeMFA    = "mfa"
+
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+)
+
+type mfaChallengeResponse struct {
+	Status   string `json:"status"`
+	MfaToken string `json:"mfaToken"`
+}
+
+type mfaEnrollResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+	QRCode          []byte `json:"qrCode" swaggertype:"string" format:"base64"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type mfaDisableRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type mfaLoginRequest struct {
+	MfaToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// completeLogin finishes a login whose first factor has been checked. Users
+// with two-factor authentication get a short-lived challenge token that has
+// to be exchanged at /auth/login/2fa; everybody else gets a session.
+func (app *application) completeLogin(c *gin.Context, user *database.User) {
+	mfa, err := app.models.MFA.Get(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if mfa != nil && mfa.ConfirmedAt != nil {
+		now := time.Now()
+		mfaToken, err := app.keys.sign(jwt.MapClaims{
+			"typ":    tokenTypeMFA,
+			"userId": user.Id,
+			"iat":    now.Unix(),
+			"exp":    now.Add(mfaTokenTTL).Unix(),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, mfaChallengeResponse{Status: "mfa_required", MfaToken: mfaToken})
+		return
+	}
+
+	app.loginSucceeded(c, user)
+
+	tokens, err := app.issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// loginMFA completes a login with a second factor
+//
+// @Summary Completes a two-factor login
+// @Description Exchanges the challenge token returned by login and a TOTP or recovery code for a session.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body mfaLoginRequest true "Challenge token and code"
+// @Success 200 {object} loginResponse
+// @Failure 401 {object} map[string]string
+// @Router /api/v1/auth/login/2fa [post]
+func (app *application) loginMFA(c *gin.Context) {
+	var req mfaLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := app.keys.parse(req.MfaToken)
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	userId, ok := claims["userId"].(float64)
+	if !ok || claims["typ"] != tokenTypeMFA {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	user, err := app.models.Users.Get(int(userId))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	keys := loginKeys(c, user.Email)
+	if !app.checkLoginAllowed(c, keys) {
+		return
+	}
+
+	valid, err := app.checkMFACode(user.Id, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if !valid {
+		app.recordLoginFailure(c, keys)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	app.loginSucceeded(c, user)
+
+	tokens, err := app.issueTokens(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// checkMFACode verifies a TOTP code, or a recovery code if allowRecovery is
+// set, against the user's confirmed enrollment. Every code works only once.
+func (app *application) checkMFACode(userId int, code string, allowRecovery bool) (bool, error) {
+	mfa, err := app.models.MFA.Get(userId)
+	if err != nil {
+		return false, err
+	}
+
+	if mfa == nil || mfa.ConfirmedAt == nil {
+		return false, nil
+	}
+
+	if step, ok := totp.Validate(mfa.Secret, code, time.Now(), 1); ok {
+		return app.models.MFA.UseStep(userId, step)
+	}
+
+	if !allowRecovery {
+		return false, nil
+	}
+
+	return app.models.MFA.UseRecoveryCode(userId, hashToken(normalizeRecoveryCode(code)))
+}
+
+// enrollMFA starts a two-factor enrollment
+//
+// @Summary Starts two-factor enrollment
+// @Description Creates a new TOTP secret and returns it as a provisioning URI and a base64 encoded QR code PNG. The enrollment has to be confirmed with a code before it is used.
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} mfaEnrollResponse
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/auth/2fa/enroll [post]
+func (app *application) enrollMFA(c *gin.Context) {
+	user := app.getUserFromContext(c)
+
+	mfa, err := app.models.MFA.Get(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if mfa != nil && mfa.ConfirmedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.models.MFA.Enroll(user.Id, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	uri := totp.ProvisioningURI(app.mfaIssuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mfaEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		QRCode:          png,
+	})
+}
+
+// confirmMFA activates two-factor authentication
+//
+// @Summary Confirms two-factor enrollment
+// @Description Activates two-factor authentication with a code from the authenticator app and returns one-time recovery codes. The recovery codes are only shown once.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body mfaCodeRequest true "TOTP code"
+// @Success 200 {object} recoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/auth/2fa/confirm [post]
+func (app *application) confirmMFA(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	mfa, err := app.models.MFA.Get(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if mfa == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor enrollment has not been started"})
+		return
+	}
+
+	if mfa.ConfirmedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, req.Code, time.Now(), 1)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.models.MFA.Confirm(user.Id, step, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// regenerateRecoveryCodes replaces the recovery codes
+//
+// @Summary Regenerates recovery codes
+// @Description Invalidates all recovery codes and returns new ones. Requires a current TOTP code.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body mfaCodeRequest true "TOTP code"
+// @Success 200 {object} recoveryCodesResponse
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/auth/2fa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodes(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	valid, err := app.checkMFACode(user.Id, req.Code, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.models.MFA.ReplaceRecoveryCodes(user.Id, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// disableMFA turns two-factor authentication off
+//
+// @Summary Disables two-factor authentication
+// @Description Removes the TOTP enrollment and recovery codes. Requires the password and a TOTP or recovery code.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body mfaDisableRequest true "Password and code"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/auth/2fa/disable [post]
+func (app *application) disableMFA(c *gin.Context) {
+	var req mfaDisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password or code"})
+		return
+	}
+
+	valid, err := app.checkMFACode(user.Id, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password or code"})
+		return
+	}
+
+	if err := app.models.MFA.Delete(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// generateRecoveryCodes returns new recovery codes for display and their
+// hashes for storage.
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxOccurrenceWindow limits how far occurrence listings expand recurring
+// events.
+const maxOccurrenceWindow = 366 * 24 * time.Hour
+
+type occurrenceListResponse struct {
+	Data  []*database.Occurrence `json:"data"`
+	Total int                    `json:"total"`
+}
+
+// validateRecurrence checks the recurrence rule of an event about to be
+// saved and stores it in canonical form.
+func validateRecurrence(event *database.Event) error {
+	rule, err := event.Rule()
+	if err != nil || rule == nil {
+		return err
+	}
+
+	if start := event.Day(); !rule.Occurs(start, start) {
+		return errors.New("startsAt must be the first occurrence of rrule")
+	}
+
+	event.RRule = rule.String()
+	return nil
+}
+
+// occurrenceParam returns the occurrence named by the occurrence query
+// parameter: a date the rule of a recurring event puts an occurrence on, or
+// empty for a one-off event. It writes the error response and returns false
+// if the parameter does not fit the event, or if the occurrence is
+// cancelled and allowCancelled is false.
+func (app *application) occurrenceParam(c *gin.Context, event *database.Event, allowCancelled bool) (string, bool) {
+	key := c.Query("occurrence")
+
+	if event.RRule == "" {
+		if key != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Event does not recur"})
+			return "", false
+		}
+		return "", true
+	}
+
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "occurrence is required for recurring events"})
+		return "", false
+	}
+
+	occurrence, err := app.models.Events.GetOccurrence(event, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return "", false
+	}
+
+	if occurrence == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Occurrence not found"})
+		return "", false
+	}
+
+	if occurrence.Cancelled && !allowCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Occurrence is cancelled"})
+		return "", false
+	}
+
+	return key, true
+}
+
+// nextOccurrence returns the first occurrence of a recurring event that has
+// not started yet and is not cancelled, or empty if there is none within a
+// year.
+func (app *application) nextOccurrence(event *database.Event) (string, error) {
+	now := time.Now()
+	occurrences, err := app.models.Events.Occurrences(database.EventFilter{
+		From: now,
+		To:   now.Add(maxOccurrenceWindow),
+	}, event.Id)
+	if err != nil {
+		return "", err
+	}
+
+	for _, occurrence := range occurrences {
+		if !occurrence.Cancelled {
+			return occurrence.Occurrence, nil
+		}
+	}
+	return "", nil
+}
+
+// parseOccurrenceWindow reads the from and to dates, inclusive, in loc or
+// in UTC if loc is nil, and returns the times the window starts and ends.
+func parseOccurrenceWindow(c *gin.Context, loc *time.Location) (time.Time, time.Time, error) {
+	from, to := c.Query("from"), c.Query("to")
+	if from == "" || to == "" {
+		return time.Time{}, time.Time{}, errors.New("from and to are required")
+	}
+
+	fromDay, err := parseDayIn(from, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("from must be a date in YYYY-MM-DD form")
+	}
+
+	toDay, err := parseDayIn(to, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("to must be a date in YYYY-MM-DD form")
+	}
+
+	if toDay.Before(fromDay) {
+		return time.Time{}, time.Time{}, errors.New("to must not be before from")
+	}
+
+	if toDay.Sub(fromDay) > maxOccurrenceWindow {
+		return time.Time{}, time.Time{}, fmt.Errorf("from and to can be at most %d days apart", int(maxOccurrenceWindow.Hours()/24))
+	}
+
+	return fromDay, toDay.AddDate(0, 0, 1), nil
+}
+
+// getOccurrences lists event occurrences in a date window
+//
+// @Summary Returns event occurrences
+// @Description Returns every occurrence starting between from and to, ordered by start time. Recurring events are expanded into their occurrences, with exceptions applied; cancelled occurrences are included and flagged. The window can span at most a year. Unlisted and private events are only included for their owner, their attendees and moderators, who have to send credentials.
+// @Tags Events
+// @Produce json
+// @Param from query string true "First date, YYYY-MM-DD"
+// @Param to query string true "Last date, YYYY-MM-DD"
+// @Param location query string false "Part of the location"
+// @Param owner query int false "Owner user ID"
+// @Param q query string false "Text in the name or description"
+// @Param tag query string false "Comma separated tags"
+// @Param match query string false "Whether events need any or all of the tags" Enums(any, all) default(any)
+// @Param status query string false "Comma separated statuses out of draft, published, cancelled and completed; all but draft by default"
+// @Param tz query string false "IANA time zone to render times in and to read from and to in; by default times are rendered in the zone of each event and dates read in UTC"
+// @Success 200 {object} occurrenceListResponse
+// @Failure 400 {object} map[string]string
+// @Router /api/v1/events/occurrences [get]
+func (app *application) getOccurrences(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	from, to, err := parseOccurrenceWindow(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := database.EventFilter{
+		From:      from,
+		To:        to,
+		Location:  c.Query("location"),
+		Query:     c.Query("q"),
+		VisibleTo: app.visibleTo(c),
+	}
+
+	if owner := c.Query("owner"); owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil || id < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "owner must be a user ID"})
+			return
+		}
+		filter.OwnerId = id
+	}
+
+	if err := parseTagFilter(c, &filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := parseStatusFilter(c, &filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	occurrences, err := app.models.Events.Occurrences(filter, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	for _, occurrence := range occurrences {
+		occurrence.In(loc)
+	}
+
+	c.JSON(http.StatusOK, occurrenceListResponse{Data: occurrences, Total: len(occurrences)})
+}
+
+// getEventOccurrences lists the occurrences of one event
+//
+// @Summary Returns the occurrences of an event
+// @Description Returns the occurrences of the event starting between from and to, ordered by start time, with exceptions applied. A one-off event has a single occurrence.
+// @Tags Events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param slug query string false "Slug of an unlisted event, for callers who neither own nor attend it"
+// @Param from query string true "First date, YYYY-MM-DD"
+// @Param to query string true "Last date, YYYY-MM-DD"
+// @Param tz query string false "IANA time zone to render times in and to read from and to in; by default times are rendered in the zone of the event and dates read in UTC"
+// @Success 200 {object} occurrenceListResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/events/{id}/occurrences [get]
+func (app *application) getEventOccurrences(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	from, to, err := parseOccurrenceWindow(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := app.models.Events.Get(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	if !app.checkCanView(c, event) {
+		return
+	}
+
+	occurrences, err := app.models.Events.Occurrences(database.EventFilter{From: from, To: to}, event.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	for _, occurrence := range occurrences {
+		occurrence.In(loc)
+	}
+
+	c.JSON(http.StatusOK, occurrenceListResponse{Data: occurrences, Total: len(occurrences)})
+}
+
+// updateOccurrence changes or cancels one occurrence of a recurring event
+//
+// @Summary Changes one occurrence
+// @Description Overrides the times, name, description or location of one occurrence of a recurring event, or cancels it. The occurrence is named by the date the rule puts it on, in the event's time zone, and keeps that name when moved. Fields left out keep the value of the series, and an occurrence moved without endsAt keeps its duration; the exception replaces any earlier one. Attendance of a cancelled occurrence is kept.
+// @Tags Events
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param occurrence path string true "Occurrence date, YYYY-MM-DD, in the event's time zone"
+// @Param request body database.EventException true "Exception"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of the event"
+// @Success 200 {object} database.Occurrence
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/occurrences/{occurrence} [put]
+func (app *application) updateOccurrence(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	var exception database.EventException
+	if err := c.ShouldBindJSON(&exception); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, key, ok := app.occurrenceForManagement(c)
+	if !ok {
+		return
+	}
+
+	exception.EventId = event.Id
+	exception.Occurrence = key
+
+	occurrence, err := app.models.Events.SetException(event, &exception)
+	if errors.Is(err, database.ErrInvalidTimes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update occurrence"})
+		return
+	}
+
+	occurrence.In(loc)
+	c.JSON(http.StatusOK, occurrence)
+}
+
+// restoreOccurrence removes the exception of an occurrence
+//
+// @Summary Restores one occurrence
+// @Description Removes the changes made to one occurrence of a recurring event, and undoes its cancellation.
+// @Tags Events
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param occurrence path string true "Occurrence date, YYYY-MM-DD, in the event's time zone"
+// @Param tz query string false "IANA time zone to render times in; by default the zone of the event"
+// @Success 200 {object} database.Occurrence
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/occurrences/{occurrence} [delete]
+func (app *application) restoreOccurrence(c *gin.Context) {
+	loc, ok := renderZone(c)
+	if !ok {
+		return
+	}
+
+	event, key, ok := app.occurrenceForManagement(c)
+	if !ok {
+		return
+	}
+
+	if _, err := app.models.Events.DeleteException(event, key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore occurrence"})
+		return
+	}
+
+	occurrence, err := app.models.Events.GetOccurrence(event, key)
+	if err != nil || occurrence == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve occurrence"})
+		return
+	}
+
+	occurrence.In(loc)
+	c.JSON(http.StatusOK, occurrence)
+}
+
+// occurrenceForManagement loads the event and the occurrence in the URL
+// for the owner of the event or a moderator. It writes the error response
+// and returns false otherwise.
+func (app *application) occurrenceForManagement(c *gin.Context) (*database.Event, string, bool) {
+	event := app.eventForManagement(c)
+	if event == nil {
+		return nil, "", false
+	}
+
+	if !checkOpen(c, event) {
+		return nil, "", false
+	}
+
+	if event.RRule == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Event does not recur"})
+		return nil, "", false
+	}
+
+	key := c.Param("occurrence")
+	occurrence, err := app.models.Events.GetOccurrence(event, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return nil, "", false
+	}
+
+	if occurrence == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Occurrence not found"})
+		return nil, "", false
+	}
+
+	return event, key, true
+}
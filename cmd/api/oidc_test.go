@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testClientId = "events-api"
+
+// mockIssuer is an OpenID Connect provider serving discovery, JWKS and the
+// token endpoint. Its authorization endpoint is called directly by the
+// tests, which stand in for the browser, through authorize.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+	// claims are the identity claims put into the next ID tokens.
+	claims jwt.MapClaims
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", m.token)
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize plays the user agreeing at the authorization endpoint: it
+// checks the request and returns the code and state the provider would
+// redirect back with.
+func (m *mockIssuer) authorize(t *testing.T, location string) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != m.URL+"/authorize" {
+		t.Fatalf("redirected to %s, want the authorization endpoint", got)
+	}
+
+	q := u.Query()
+	if q.Get("client_id") != testClientId || q.Get("response_type") != "code" {
+		t.Fatalf("authorization request %v is not a code request for the client", q)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request %v has no S256 PKCE challenge", q)
+	}
+	if q.Get("state") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorization request %v has no state or nonce", q)
+	}
+
+	code = "code-" + q.Get("state")
+	m.mu.Lock()
+	m.grants[code] = mockGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	m.mu.Unlock()
+
+	return code, q.Get("state")
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	grant, ok := m.grants[r.PostForm.Get("code")]
+	delete(m.grants, r.PostForm.Get("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"invalid_grant"}`)
+		return
+	}
+
+	now := time.Now()
+	claims["iss"] = m.URL
+	claims["aud"] = testClientId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Minute).Unix()
+	claims["nonce"] = grant.nonce
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+// newTestApplication returns an application on a fresh, migrated database
+// that signs users in with the issuer.
+func newTestApplication(t *testing.T, issuer *mockIssuer) *application {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on&_txlock=immediate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.CheckFTS5(db); errors.Is(err, database.ErrNoFTS5) {
+		t.Skip("run with -tags sqlite_fts5")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := filepath.Glob("../migrate/migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		query, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(query)); err != nil {
+			t.Fatalf("%s: %v", migration, err)
+		}
+	}
+
+	keys, err := loadKeySet("", nil, strings.Repeat("k", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := database.NewModels(db)
+	revocations, err := newRevocationStore(&models.Revocations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := newOIDCProvider(issuer.URL, testClientId, "secret", "http://localhost:8080/api/v1/auth/oidc/callback", []string{"email", "profile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		keys:            keys,
+		accessTokenTTL:  15 * time.Minute,
+		refreshTokenTTL: time.Hour,
+		baseURL:         "http://localhost:8080",
+		models:          models,
+		revocations:     revocations,
+		mailer:          &mailer.WriterMailer{From: "Events <no-reply@localhost>", W: io.Discard},
+		loginAttempts:   newMemoryAttemptStore(),
+		loginPolicy:     loginPolicy{maxFailures: 5, maxIPFailures: 20, window: time.Minute, lockout: time.Minute},
+		oidc:            provider,
+	}
+}
+
+// oidcLoginFlow starts a login and returns the redirect location and the
+// flow cookie.
+func oidcLoginFlow(t *testing.T, handler http.Handler) (string, *http.Cookie) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d, want 302: %s", rec.Code, rec.Body)
+	}
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcFlowCookie {
+			return rec.Header().Get("Location"), cookie
+		}
+	}
+	t.Fatal("login set no flow cookie")
+	return "", nil
+}
+
+func oidcCallback(handler http.Handler, cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	req.AddCookie(cookie)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDCLoginRoundTrip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	app := newTestApplication(t, issuer)
+	handler := app.routes()
+
+	issuer.claims = jwt.MapClaims{"sub": "alice-1", "email": "alice@example.com", "email_verified": true, "name": "Alice"}
+
+	location, cookie := oidcLoginFlow(t, handler)
+	code, state := issuer.authorize(t, location)
+
+	if rec := oidcCallback(handler, cookie, code, state+"x"); rec.Code != http.StatusBadRequest {
+		t.Errorf("callback with another state: status %d, want 400", rec.Code)
+	}
+
+	rec := oidcCallback(handler, cookie, code, state)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var tokens loginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil || tokens.Token == "" {
+		t.Fatalf("callback returned no session: %s", rec.Body)
+	}
+
+	user, err := app.models.Users.GetByEmail("alice@example.com")
+	if err != nil || user == nil {
+		t.Fatalf("no account was created: %v", err)
+	}
+	if user.VerifiedAt == nil {
+		t.Error("created account is not verified")
+	}
+
+	identity, err := app.models.Identities.Get(issuer.URL, "alice-1")
+	if err != nil || identity == nil || identity.UserId != user.Id {
+		t.Errorf("identity = %+v, %v, want one linked to user %d", identity, err, user.Id)
+	}
+
+	// The code was used up by the exchange, and a new flow with a code
+	// whose verifier does not match its challenge fails.
+	location, cookie = oidcLoginFlow(t, handler)
+	code, state = issuer.authorize(t, location)
+	issuer.mu.Lock()
+	grant := issuer.grants[code]
+	grant.challenge = "another-challenge"
+	issuer.grants[code] = grant
+	issuer.mu.Unlock()
+
+	if rec := oidcCallback(handler, cookie, code, state); rec.Code != http.StatusBadRequest {
+		t.Errorf("callback with a mismatching PKCE verifier: status %d, want 400", rec.Code)
+	}
+}
+
+func TestOIDCLinksAccountByVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	app := newTestApplication(t, issuer)
+	handler := app.routes()
+
+	existing := &database.User{Email: "bob@example.com", Name: "Bob", Password: "hash"}
+	if err := app.models.Users.Insert(existing); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.Users.MarkVerified(existing.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.claims = jwt.MapClaims{"sub": "bob-1", "email": "bob@example.com", "email_verified": true, "name": "Robert"}
+
+	location, cookie := oidcLoginFlow(t, handler)
+	code, state := issuer.authorize(t, location)
+	if rec := oidcCallback(handler, cookie, code, state); rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	identity, err := app.models.Identities.Get(issuer.URL, "bob-1")
+	if err != nil || identity == nil || identity.UserId != existing.Id {
+		t.Fatalf("identity = %+v, %v, want one linked to user %d", identity, err, existing.Id)
+	}
+
+	// A verified account is trusted, so it keeps its password.
+	user, err := app.models.Users.Get(existing.Id)
+	if err != nil || user == nil || user.Password != "hash" {
+		t.Errorf("linked account = %+v, %v, want its password kept", user, err)
+	}
+}
+
+func TestOIDCTakesOverUnverifiedAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	app := newTestApplication(t, issuer)
+	handler := app.routes()
+
+	squatter := &database.User{Email: "dave@example.com", Name: "Mallory", Password: "hash"}
+	if err := app.models.Users.Insert(squatter); err != nil {
+		t.Fatal(err)
+	}
+	key := &database.APIKey{UserId: squatter.Id, Name: "ci", Prefix: "evk_test", KeyHash: "keyhash", Scopes: []string{permEventsRead}, CreatedAt: time.Now().UTC()}
+	if err := app.models.APIKeys.Insert(key); err != nil {
+		t.Fatal(err)
+	}
+	feed := &database.CalendarFeed{UserId: squatter.Id, Name: "phone", TokenHash: "feedhash", CreatedAt: time.Now().UTC()}
+	if err := app.models.CalendarFeeds.Insert(feed); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.MFA.Enroll(squatter.Id, "SECRET"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.models.MFA.Confirm(squatter.Id, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.claims = jwt.MapClaims{"sub": "dave-1", "email": "dave@example.com", "email_verified": true}
+
+	location, cookie := oidcLoginFlow(t, handler)
+	code, state := issuer.authorize(t, location)
+	rec := oidcCallback(handler, cookie, code, state)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	// The squatter's second factor is gone, so the owner gets a session.
+	var tokens loginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil || tokens.Token == "" {
+		t.Fatalf("callback returned no session: %s", rec.Body)
+	}
+
+	user, err := app.models.Users.Get(squatter.Id)
+	if err != nil || user == nil || user.Password != "" || user.VerifiedAt == nil {
+		t.Errorf("account = %+v, %v, want it verified without a password", user, err)
+	}
+	if keys, err := app.models.APIKeys.GetAllForUser(squatter.Id); err != nil || len(keys) != 0 {
+		t.Errorf("API keys = %v, %v, want all revoked", keys, err)
+	}
+	if feeds, err := app.models.CalendarFeeds.GetAllForUser(squatter.Id); err != nil || len(feeds) != 0 {
+		t.Errorf("calendar feeds = %v, %v, want all revoked", feeds, err)
+	}
+	if mfa, err := app.models.MFA.Get(squatter.Id); err != nil || mfa != nil {
+		t.Errorf("MFA = %+v, %v, want none", mfa, err)
+	}
+}
+
+func TestOIDCRejectsUnverifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	issuer := newMockIssuer(t)
+	app := newTestApplication(t, issuer)
+	handler := app.routes()
+
+	existing := &database.User{Email: "carol@example.com", Name: "Carol", Password: "hash"}
+	if err := app.models.Users.Insert(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	issuer.claims = jwt.MapClaims{"sub": "mallory-1", "email": "carol@example.com", "email_verified": false}
+
+	location, cookie := oidcLoginFlow(t, handler)
+	code, state := issuer.authorize(t, location)
+	if rec := oidcCallback(handler, cookie, code, state); rec.Code != http.StatusForbidden {
+		t.Fatalf("callback: status %d, want 403: %s", rec.Code, rec.Body)
+	}
+
+	identity, err := app.models.Identities.Get(issuer.URL, "mallory-1")
+	if err != nil || identity != nil {
+		t.Errorf("identity = %+v, %v, want none", identity, err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// forgotPassword sends a password reset link
+//
+// @Summary Requests a password reset
+// @Description Emails a single-use password reset link if an account with the address exists. The response is the same either way.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body forgotPasswordRequest true "Account email"
+// @Success 202 {object} map[string]string
+// @Router /api/v1/auth/password/forgot [post]
+func (app *application) forgotPassword(c *gin.Context) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if user != nil {
+		if err := app.sendPasswordReset(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account with that email exists, a password reset link has been sent"})
+}
+
+func (app *application) sendPasswordReset(user *database.User) error {
+	if err := app.models.PasswordResets.InvalidateForUser(user.Id); err != nil {
+		return err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = app.models.PasswordResets.Insert(&database.PasswordReset{
+		UserId:    user.Id,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(app.passwordResetTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", app.baseURL, url.QueryEscape(token))
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomebody asked to reset the password of your account. Open the link below to choose a new one:\n\n%s\n\nThe link expires in %d minutes. If you did not ask for this, you can ignore this email.\n",
+			user.Name, link, int(app.passwordResetTTL.Minutes())),
+	})
+
+	return nil
+}
+
+// resetPassword sets a new password using a reset token
+//
+// @Summary Resets a password
+// @Description Sets a new password using the token from a password reset email and ends every session of the account.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body resetPasswordRequest true "Reset token and new password"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Router /api/v1/auth/password/reset [post]
+func (app *application) resetPassword(c *gin.Context) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	reset, err := app.models.PasswordResets.GetByHash(hashToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if reset == nil || reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	used, err := app.models.PasswordResets.MarkUsed(reset.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if !used {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.models.Users.UpdatePassword(reset.UserId, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	if err := app.models.PasswordResets.InvalidateForUser(reset.UserId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if err := app.revokeAllSessions(reset.UserId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"rest-go-gin/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// permEventsRead allows reading events and attendance through
+	// authenticated endpoints.
+	permEventsRead = "events:read"
+	// permEventsWrite allows creating events and changing the caller's own events.
+	permEventsWrite = "events:write"
+	// permAttendeesWrite allows managing the attendees of the caller's own events.
+	permAttendeesWrite = "attendees:write"
+	// permRSVPWrite allows signing the caller up for events and answering
+	// invitations.
+	permRSVPWrite = "rsvp:write"
+	// permEventsModerate allows changing and deleting any event.
+	permEventsModerate = "events:moderate"
+	// permUsersManage allows administering other user accounts.
+	permUsersManage = "users:manage"
+)
+
+// rolePermissions lists what each role may do. Every user may create and run
+// events of their own; organizers hold the same permissions, and admins
+// also moderate events and manage users.
+var rolePermissions = map[string][]string{
+	database.RoleUser:      {permEventsRead, permEventsWrite, permAttendeesWrite, permRSVPWrite},
+	database.RoleOrganizer: {permEventsRead, permEventsWrite, permAttendeesWrite, permRSVPWrite},
+	database.RoleAdmin:     {permEventsRead, permEventsWrite, permAttendeesWrite, permRSVPWrite, permEventsModerate, permUsersManage},
+}
+
+func hasPermission(user *database.User, permission string) bool {
+	for _, p := range rolePermissions[user.Role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// can reports whether the caller holds permission. Requests made with an API
+// key are further limited to the scopes of that key.
+func (app *application) can(c *gin.Context, permission string) bool {
+	if !hasPermission(app.getUserFromContext(c), permission) {
+		return false
+	}
+
+	if key := app.getAPIKeyFromContext(c); key != nil {
+		for _, scope := range key.Scopes {
+			if scope == permission {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// canManageEvent reports whether the caller may change event: owners manage
+// their own events and moderators manage everybody's.
+func (app *application) canManageEvent(c *gin.Context, event *database.Event) bool {
+	return event.OwnerId == app.getUserFromContext(c).Id || app.can(c, permEventsModerate)
+}
+
+// visibleTo returns the EventFilter.VisibleTo of the caller. Moderators see
+// every event; others see public events and, if they may read events, the
+// ones they own or attend. Anonymous callers count as user 0.
+func (app *application) visibleTo(c *gin.Context) *int {
+	if app.can(c, permEventsModerate) {
+		return nil
+	}
+
+	var id int
+	if app.can(c, permEventsRead) {
+		id = app.getUserFromContext(c).Id
+	}
+	return &id
+}
+
+// canViewEvent reports whether the caller can see event, as visibleTo
+// decides. Unlisted events can also be seen with their slug in the slug
+// query parameter. Drafts are only seen by those who manage them.
+func (app *application) canViewEvent(c *gin.Context, event *database.Event) (bool, error) {
+	if event.Status == database.EventDraft {
+		return app.canManageEvent(c, event), nil
+	}
+
+	if event.Visibility == database.VisibilityPublic {
+		return true, nil
+	}
+
+	if event.Slug != nil && subtle.ConstantTimeCompare([]byte(c.Query("slug")), []byte(*event.Slug)) == 1 {
+		return true, nil
+	}
+
+	visibleTo := app.visibleTo(c)
+	switch {
+	case visibleTo == nil:
+		return true, nil
+	case *visibleTo == 0:
+		return false, nil
+	case event.OwnerId == *visibleTo:
+		return true, nil
+	}
+
+	return app.models.Attendees.Attends(event.Id, *visibleTo)
+}
+
+// checkCanView answers 404 unless the caller can see event, so events they
+// cannot see look like missing ones. It reports whether the request may go
+// on.
+func (app *application) checkCanView(c *gin.Context, event *database.Event) bool {
+	ok, err := app.canViewEvent(c, event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return false
+	}
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return false
+	}
+	return true
+}
+
+// RequirePermission rejects the request unless the authenticated user's role,
+// and the API key if one was used, grant every one of the given permissions.
+// It must run after AuthMiddleWare.
+func (app *application) RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !app.can(c, permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to perform this action"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects requests authenticated with an API key. It guards
+// endpoints that manage the account itself, so a leaked key cannot be used to
+// mint more keys or change credentials.
+func (app *application) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.getAPIKeyFromContext(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
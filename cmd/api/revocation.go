@@ -0,0 +1,106 @@
+package main
+
+import (
+	"rest-go-gin/internal/database"
+	"sync"
+	"time"
+)
+
+// revocationStore keeps revoked access tokens in memory so AuthMiddleWare can
+// check them without a database round trip. Every change is written through
+// to SQLite and the cache is reloaded from there on startup.
+type revocationStore struct {
+	mu       sync.RWMutex
+	model    *database.RevocationModel
+	tokens   map[string]time.Time
+	sessions map[int]time.Time
+}
+
+func newRevocationStore(model *database.RevocationModel) (*revocationStore, error) {
+	s := &revocationStore{
+		model:    model,
+		tokens:   make(map[string]time.Time),
+		sessions: make(map[int]time.Time),
+	}
+
+	now := time.Now().UTC()
+	if err := model.DeleteExpiredTokens(now); err != nil {
+		return nil, err
+	}
+
+	tokens, err := model.GetActiveTokens(now)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		s.tokens[token.Jti] = token.ExpiresAt
+	}
+
+	sessions, err := model.GetSessionRevocations()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		s.sessions[session.UserId] = session.RevokedBefore
+	}
+
+	return s, nil
+}
+
+// RevokeToken rejects the access token with the given jti until expiresAt.
+func (s *revocationStore) RevokeToken(jti string, userId int, expiresAt time.Time) error {
+	err := s.model.RevokeToken(&database.RevokedToken{
+		Jti:       jti,
+		UserId:    userId,
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range s.tokens {
+		if now.After(exp) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[jti] = expiresAt
+
+	return nil
+}
+
+// RevokeSessions rejects every access token issued to userId before now.
+func (s *revocationStore) RevokeSessions(userId int) error {
+	now := time.Now().UTC()
+	err := s.model.RevokeSessions(&database.SessionRevocation{
+		UserId:        userId,
+		RevokedBefore: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.sessions[userId] = now
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *revocationStore) IsRevoked(jti string, userId int, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok {
+		return true
+	}
+
+	if revokedBefore, ok := s.sessions[userId]; ok && issuedAt.Before(revokedBefore) {
+		return true
+	}
+
+	return false
+}
@@ -14,26 +14,90 @@ func (app *application) routes() http.Handler{
 
 	v1 := g.Group("/api/v1")
 	{
-		v1.GET("/events", app.getAllEvents)
-		v1.GET("/events/:id",app.getEvent)
-		v1.GET("/events/:id/attendees", app.getAttendeesForEvent)
-		v1.GET("/attendees/:id/events", app.getEventsByAttendee)
 		v1.POST("/auth/register", app.registerUser)
 		v1.POST("/auth/login", app.login)
+		v1.POST("/auth/login/2fa", app.loginMFA)
+		v1.POST("/auth/refresh", app.refresh)
+		v1.POST("/auth/password/forgot", app.forgotPassword)
+		v1.POST("/auth/password/reset", app.resetPassword)
+		v1.GET("/auth/verify", app.verifyEmail)
+		v1.POST("/auth/verify/resend", app.resendVerification)
+		v1.GET("/auth/oidc/login", app.oidcLogin)
+		v1.GET("/auth/oidc/callback", app.oidcCallback)
+		v1.GET("/invitations/:token", app.getInvitation)
+		v1.GET("/calendar/:feed", app.getCalendarFeed)
+		v1.POST("/invitations/:token/decline", app.declineInvitation)
+	}
+
+	// Events are public, but callers who send credentials also see the
+	// unlisted and private events they own or attend.
+	viewGroup := v1.Group("/")
+	viewGroup.Use(app.OptionalAuth())
+	{
+		viewGroup.GET("/events", app.getAllEvents)
+		viewGroup.GET("/events/search", app.searchEvents)
+		viewGroup.GET("/events/occurrences", app.getOccurrences)
+		viewGroup.GET("/events/slug/:slug", app.getEventBySlug)
+		viewGroup.GET("/events/:id",app.getEvent)
+		viewGroup.GET("/events/:id/attendees", app.getAttendeesForEvent)
+		viewGroup.GET("/events/:id/occurrences", app.getEventOccurrences)
+		viewGroup.GET("/events/:id/ics", app.getEventICS)
+		viewGroup.GET("/attendees/:id/events", app.getEventsByAttendee)
+		viewGroup.GET("/tags", app.getTags)
 	}
 
 	authGroup := v1.Group("/")
 	authGroup.Use(app.AuthMiddleWare())
 	{
-		authGroup.POST("/events",app.createEvent)
-		authGroup.PUT("/events/:id",app.updateEvent)
-		authGroup.DELETE("/events/:id",app.deleteEvent)
-		authGroup.POST("/events/:id/attendees/:userId", app.addAttendeeToEvent)
-		authGroup.DELETE("/events/:id/attendees/:userId",app.deleteAttendeeFromEvent)
-
+		authGroup.GET("/me", app.getMe)
+		authGroup.GET("/me/events", app.RequirePermission(permEventsRead), app.getMyEvents)
+		authGroup.GET("/me/attending", app.RequirePermission(permEventsRead), app.getMyAttendance)
+		authGroup.POST("/events", app.RequirePermission(permEventsWrite), app.createEvent)
+		authGroup.POST("/events/import", app.RequirePermission(permEventsWrite), app.importEvents)
+		authGroup.PUT("/events/:id", app.RequirePermission(permEventsWrite), app.updateEvent)
+		authGroup.DELETE("/events/:id", app.RequirePermission(permEventsWrite), app.deleteEvent)
+		authGroup.POST("/events/:id/restore", app.RequirePermission(permEventsWrite), app.restoreEvent)
+		authGroup.POST("/events/:id/publish", app.RequirePermission(permEventsWrite), app.publishEvent)
+		authGroup.POST("/events/:id/cancel", app.RequirePermission(permEventsWrite), app.cancelEvent)
+		authGroup.PUT("/events/:id/occurrences/:occurrence", app.RequirePermission(permEventsWrite), app.updateOccurrence)
+		authGroup.DELETE("/events/:id/occurrences/:occurrence", app.RequirePermission(permEventsWrite), app.restoreOccurrence)
+		authGroup.POST("/events/:id/attendees/:userId", app.RequirePermission(permAttendeesWrite), app.addAttendeeToEvent)
+		authGroup.DELETE("/events/:id/attendees/:userId", app.RequirePermission(permAttendeesWrite), app.deleteAttendeeFromEvent)
+		authGroup.POST("/events/:id/attendees/:userId/approve", app.RequirePermission(permAttendeesWrite), app.approveAttendee)
+		authGroup.POST("/events/:id/rsvp", app.RequirePermission(permRSVPWrite), app.rsvpEvent)
+		authGroup.DELETE("/events/:id/rsvp", app.RequirePermission(permRSVPWrite), app.cancelRSVP)
+		authGroup.GET("/events/:id/invitations", app.RequirePermission(permAttendeesWrite), app.getEventInvitations)
+		authGroup.POST("/events/:id/invitations", app.RequirePermission(permAttendeesWrite), app.createInvitation)
+		authGroup.DELETE("/events/:id/invitations/:invitationId", app.RequirePermission(permAttendeesWrite), app.revokeInvitation)
+		authGroup.POST("/invitations/:token/accept", app.RequirePermission(permRSVPWrite), app.acceptInvitation)
+		authGroup.PUT("/admin/users/:id/role", app.RequirePermission(permUsersManage), app.updateUserRole)
+		authGroup.POST("/admin/users/:id/unlock", app.RequirePermission(permUsersManage), app.unlockUser)
+		authGroup.POST("/admin/users/:id/restore", app.RequirePermission(permUsersManage), app.restoreUser)
+	}
 
+	// Account management needs a real session; API keys are rejected here.
+	sessionGroup := authGroup.Group("/")
+	sessionGroup.Use(app.RequireSession())
+	{
+		sessionGroup.POST("/auth/logout", app.logout)
+		sessionGroup.POST("/auth/logout-all", app.logoutAll)
+		sessionGroup.POST("/auth/2fa/enroll", app.enrollMFA)
+		sessionGroup.POST("/auth/2fa/confirm", app.confirmMFA)
+		sessionGroup.POST("/auth/2fa/recovery-codes", app.regenerateRecoveryCodes)
+		sessionGroup.POST("/auth/2fa/disable", app.disableMFA)
+		sessionGroup.PATCH("/me", app.updateMe)
+		sessionGroup.POST("/me/password", app.changePassword)
+		sessionGroup.DELETE("/me", app.deleteMe)
+		sessionGroup.GET("/me/api-keys", app.getAPIKeys)
+		sessionGroup.POST("/me/api-keys", app.createAPIKey)
+		sessionGroup.DELETE("/me/api-keys/:id", app.revokeAPIKey)
+		sessionGroup.GET("/me/calendar-feeds", app.getCalendarFeeds)
+		sessionGroup.POST("/me/calendar-feeds", app.createCalendarFeed)
+		sessionGroup.DELETE("/me/calendar-feeds/:id", app.revokeCalendarFeed)
 	}
 
+	g.GET("/.well-known/jwks.json", app.getJWKS)
+
 	g.GET("/swagger/*any", func(c *gin.Context){
 		if c.Request.RequestURI == "/swagger/"{
 			c.Redirect(302, "/swagger/index.html")
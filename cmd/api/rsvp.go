@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type rsvpRequest struct {
+	RSVP string `json:"rsvp" binding:"required,oneof=going maybe declined"`
+}
+
+// rsvpEvent answers an event invitation for the current user
+//
+// @Summary RSVPs to an event
+// @Description Signs the authenticated user up for the event or changes their answer. Depending on the event's RSVP policy, new attendees are confirmed right away, wait for the owner's approval, or are rejected unless the owner added them or invited them. Attendees who are going but find the event full are waitlisted. Each occurrence of a recurring event has its own attendees and seats; attendees admitted to one occurrence can sign up for the others regardless of the policy.
+// @Tags Attendees
+// @Accept json
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param slug query string false "Slug of an unlisted event, for callers who neither own nor attend it"
+// @Param occurrence query string false "Occurrence date, YYYY-MM-DD, required for recurring events"
+// @Param request body rsvpRequest true "Answer"
+// @Success 200 {object} database.Attendee
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/rsvp [post]
+func (app *application) rsvpEvent(c *gin.Context) {
+	eventId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	var req rsvpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := app.models.Events.Get(eventId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	if !app.checkCanView(c, event) {
+		return
+	}
+
+	if !checkOpen(c, event) {
+		return
+	}
+
+	occurrence, ok := app.occurrenceParam(c, event, false)
+	if !ok {
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	attendee, promoted, err := app.models.Attendees.RSVP(event.Id, occurrence, user.Id, req.RSVP)
+	if errors.Is(err, database.ErrNotInvited) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This event is invite-only"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save RSVP"})
+		return
+	}
+
+	app.notifyPromoted(event, promoted)
+
+	c.JSON(http.StatusOK, attendee)
+}
+
+// cancelRSVP removes the current user from an event
+//
+// @Summary Withdraws from an event
+// @Description Removes the authenticated user from the attendees of the event, or of one occurrence of a recurring event. A seat they held goes to the waitlist. Attendees of invite-only events cannot sign up again afterwards unless they accepted an invitation or attend another occurrence; answer declined to stay invited. Attendance of cancelled and completed events cannot change.
+// @Tags Attendees
+// @Param id path int true "Event ID"
+// @Param occurrence query string false "Occurrence date, YYYY-MM-DD, required for recurring events"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/rsvp [delete]
+func (app *application) cancelRSVP(c *gin.Context) {
+	eventId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	event, err := app.models.Events.Get(eventId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	// Cancelled and completed events keep their attendance as it was.
+	if !checkOpen(c, event) {
+		return
+	}
+
+	occurrence, ok := app.occurrenceParam(c, event, true)
+	if !ok {
+		return
+	}
+
+	user := app.getUserFromContext(c)
+
+	attendee, err := app.models.Attendees.GetByEventAndAttendee(event.Id, occurrence, user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if attendee == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You are not attending this event"})
+		return
+	}
+
+	promoted, err := app.models.Attendees.Delete(user.Id, event.Id, occurrence)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel RSVP"})
+		return
+	}
+
+	app.notifyPromoted(event, promoted)
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// approveAttendee admits a pending attendee
+//
+// @Summary Approves an attendee
+// @Description Admits an attendee of an event that needs approval. They are waitlisted if they are going and the event is full.
+// @Tags Attendees
+// @Produce json
+// @Param id path int true "Event ID"
+// @Param userId path int true "User ID"
+// @Param occurrence query string false "Occurrence date, YYYY-MM-DD, required for recurring events"
+// @Success 200 {object} database.Attendee
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security BearerAuth
+// @Router /api/v1/events/{id}/attendees/{userId}/approve [post]
+func (app *application) approveAttendee(c *gin.Context) {
+	eventId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	event, err := app.models.Events.Get(eventId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event"})
+		return
+	}
+
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	if !app.canManageEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to approve attendees"})
+		return
+	}
+
+	if !checkOpen(c, event) {
+		return
+	}
+
+	occurrence, ok := app.occurrenceParam(c, event, false)
+	if !ok {
+		return
+	}
+
+	existing, err := app.models.Attendees.GetByEventAndAttendee(event.Id, occurrence, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attendee not found"})
+		return
+	}
+
+	attendee, err := app.models.Attendees.Approve(event.Id, occurrence, userId)
+	if err != nil || attendee == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve attendee"})
+		return
+	}
+
+	if existing.Status == database.AttendeePending {
+		app.notifyApproved(event, attendee)
+	}
+
+	c.JSON(http.StatusOK, attendee)
+}
+
+// notifyApproved tells an attendee that the owner approved them.
+func (app *application) notifyApproved(event *database.Event, attendee *database.Attendee) {
+	user, err := app.models.Users.Get(attendee.UserId)
+	if err != nil || user == nil {
+		log.Printf("failed to notify approved attendee %d: %v", attendee.UserId, err)
+		return
+	}
+
+	date := app.attendanceTime(event, attendee)
+	body := fmt.Sprintf("Hi %s,\n\nyour request to attend %s on %s was approved.\n", user.Name, event.Name, date)
+	if attendee.Status == database.AttendeeWaitlisted {
+		body = fmt.Sprintf("Hi %s,\n\nyour request to attend %s on %s was approved. The event is full, so you are number %d on the waitlist.\n",
+			user.Name, event.Name, date, *attendee.WaitlistPosition)
+	}
+
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "You can attend " + event.Name,
+		Body:    body,
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateTags checks the tags of an event about to be saved and stores
+// them normalized.
+func validateTags(event *database.Event) error {
+	for i, tag := range event.Tags {
+		name := database.NormalizeTag(tag)
+		if !database.ValidTag(name) {
+			return fmt.Errorf("tag %q may only contain letters, digits, hyphens and spaces", tag)
+		}
+		event.Tags[i] = name
+	}
+	return nil
+}
+
+// parseTagFilter reads the tag and match parameters into the filter.
+func parseTagFilter(c *gin.Context, filter *database.EventFilter) error {
+	switch c.DefaultQuery("match", "any") {
+	case "any":
+	case "all":
+		filter.MatchAllTags = true
+	default:
+		return errors.New("match must be any or all")
+	}
+
+	tags := c.Query("tag")
+	if tags == "" {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range strings.Split(tags, ",") {
+		name := database.NormalizeTag(tag)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			filter.Tags = append(filter.Tags, name)
+		}
+	}
+	return nil
+}
+
+// getTags lists the tags in use
+//
+// @Summary Returns tags
+// @Description Returns every tag events have, with the number of events that have it, the most used first. Only events the caller can see are counted.
+// @Tags Events
+// @Produce json
+// @Success 200 {object} []database.Tag
+// @Router /api/v1/tags [get]
+func (app *application) getTags(c *gin.Context) {
+	tags, err := app.models.Tags.GetAll(database.EventFilter{Statuses: listedStatuses, VisibleTo: app.visibleTo(c)})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
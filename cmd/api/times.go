@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"rest-go-gin/internal/database"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errInvalidZone = errors.New("tz must be an IANA time zone such as Europe/Berlin")
+
+// requestZone returns the time zone named by the tz query parameter, or nil
+// if there is none.
+func requestZone(c *gin.Context) (*time.Location, error) {
+	tz := c.Query("tz")
+	if tz == "" {
+		return nil, nil
+	}
+
+	if strings.EqualFold(tz, "local") {
+		return nil, errInvalidZone
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errInvalidZone
+	}
+	return loc, nil
+}
+
+// renderZone returns the time zone the caller wants event times rendered
+// in, or nil to render every event in its own zone. It writes the error
+// response and returns false if tz is not a time zone.
+func renderZone(c *gin.Context) (*time.Location, bool) {
+	loc, err := requestZone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return loc, true
+}
+
+// parseDayIn parses a date in YYYY-MM-DD form and returns the start of that
+// day in loc, or in UTC if loc is nil.
+func parseDayIn(value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+// formatEventTime formats a time of the event for people, in the event's
+// time zone.
+func formatEventTime(event *database.Event, t time.Time) string {
+	return t.In(event.Zone()).Format("Monday, January 2, 2006 15:04 MST")
+}
+
+// eventsIn converts the times of events to loc for rendering, or each to its
+// own zone if loc is nil.
+func eventsIn(loc *time.Location, events []*database.Event) {
+	for _, event := range events {
+		event.In(loc)
+	}
+}
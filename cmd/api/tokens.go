@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math"
+	"rest-go-gin/internal/database"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// generateToken returns a URL-safe random string suitable for opaque tokens.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the value stored in the database for an opaque token, so
+// a leaked table cannot be replayed against the API.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (app *application) newAccessToken(user *database.User) (string, error) {
+	jti, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return app.keys.sign(jwt.MapClaims{
+		"typ":    tokenTypeAccess,
+		"userId": user.Id,
+		"role":   user.Role,
+		"jti":    jti,
+		// iat keeps sub-second precision so a token issued right after
+		// logout-all is not caught by the session revocation it follows.
+		"iat": float64(now.UnixNano()) / float64(time.Second),
+		"exp": now.Add(app.accessTokenTTL).Unix(),
+	})
+}
+
+// claimTime converts a NumericDate claim such as exp or iat to a time.Time.
+func claimTime(claims jwt.MapClaims, key string) time.Time {
+	value, _ := claims[key].(float64)
+	sec, frac := math.Modf(value)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second)))
+}
+
+// newRefreshToken stores a new refresh token for user in the given family and
+// returns the plain token. An empty familyId starts a new family.
+func (app *application) newRefreshToken(userId int, familyId string) (string, *database.RefreshToken, error) {
+	plain, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if familyId == "" {
+		familyId, err = generateToken()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	return plain, &database.RefreshToken{
+		UserId:    userId,
+		FamilyId:  familyId,
+		TokenHash: hashToken(plain),
+		ExpiresAt: now.Add(app.refreshTokenTTL),
+		CreatedAt: now,
+	}, nil
+}
+
+// issueTokens starts a new session for user.
+func (app *application) issueTokens(user *database.User) (*loginResponse, error) {
+	accessToken, err := app.newAccessToken(user)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, record, err := app.newRefreshToken(user.Id, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := app.models.RefreshTokens.Insert(record); err != nil {
+		return nil, err
+	}
+
+	return &loginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(app.accessTokenTTL.Seconds()),
+	}, nil
+}
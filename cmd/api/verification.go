@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type resendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// sendVerificationEmail emails user a link that proves they own email.
+func (app *application) sendVerificationEmail(user *database.User, email string) error {
+	if err := app.models.Verifications.InvalidateForUser(user.Id); err != nil {
+		return err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = app.models.Verifications.Insert(&database.EmailVerification{
+		UserId:    user.Id,
+		Email:     email,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(app.verificationTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/auth/verify?token=%s", app.baseURL, url.QueryEscape(token))
+	app.sendMail(&mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %d hours.\n",
+			user.Name, link, int(app.verificationTTL.Hours())),
+	})
+
+	return nil
+}
+
+// verifyEmail confirms an email address
+//
+// @Summary Verifies an email address
+// @Description Confirms the email address a verification link was sent to. If the link was sent for an email change, the account switches to the new address.
+// @Tags Auth
+// @Produce json
+// @Param token query string true "Verification token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /api/v1/auth/verify [get]
+func (app *application) verifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Verification token is required"})
+		return
+	}
+
+	verification, err := app.models.Verifications.GetByHash(hashToken(token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if verification == nil || verification.UsedAt != nil || time.Now().After(verification.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	user, err := app.models.Users.Get(verification.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	// A token for an address other than the current one confirms an email
+	// change requested through PATCH /me.
+	changing := user.Email != verification.Email
+	if changing {
+		other, err := app.models.Users.GetByEmail(verification.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if other != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email address is already in use"})
+			return
+		}
+	}
+
+	used, err := app.models.Verifications.MarkUsed(verification.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if !used {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	if changing {
+		if err := app.models.Users.UpdateEmail(user.Id, verification.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email"})
+			return
+		}
+
+		app.audit(c, user, "account.email_changed", "user:"+strconv.Itoa(user.Id), user.Email+" -> "+verification.Email)
+		c.JSON(http.StatusOK, gin.H{"message": "Email address changed"})
+		return
+	}
+
+	if err := app.models.Users.MarkVerified(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+// resendVerification sends a new verification link
+//
+// @Summary Resends the verification email
+// @Description Sends a new verification link if an unverified account with the address exists. The response is the same either way.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param request body resendVerificationRequest true "Account email"
+// @Success 202 {object} map[string]string
+// @Router /api/v1/auth/verify/resend [post]
+func (app *application) resendVerification(c *gin.Context) {
+	var req resendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+
+	if user != nil && user.VerifiedAt == nil {
+		if err := app.sendVerificationEmail(user, user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an unverified account with that email exists, a verification link has been sent"})
+}
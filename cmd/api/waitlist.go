@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"rest-go-gin/internal/database"
+	"rest-go-gin/internal/mailer"
+)
+
+// notifyPromoted tells attendees who moved up from the waitlist that they
+// now have a seat.
+func (app *application) notifyPromoted(event *database.Event, promoted []*database.Attendee) {
+	for _, attendee := range promoted {
+		user, err := app.models.Users.Get(attendee.UserId)
+		if err != nil || user == nil {
+			log.Printf("failed to notify promoted attendee %d: %v", attendee.UserId, err)
+			continue
+		}
+
+		app.sendMail(&mailer.Message{
+			To:      user.Email,
+			Subject: "You have a seat at " + event.Name,
+			Body: fmt.Sprintf("Hi %s,\n\na seat opened up and you have been moved from the waitlist to the attendee list of %s on %s.\n",
+				user.Name, event.Name, app.attendanceTime(event, attendee)),
+		})
+	}
+}
+
+// attendanceTime returns when the event the attendee is attending starts,
+// or their occurrence of a recurring event, formatted for emails.
+func (app *application) attendanceTime(event *database.Event, attendee *database.Attendee) string {
+	if attendee.Occurrence == "" {
+		return formatEventTime(event, event.StartsAt)
+	}
+
+	occurrence, err := app.models.Events.GetOccurrence(event, attendee.Occurrence)
+	if err != nil || occurrence == nil {
+		return attendee.Occurrence
+	}
+	return formatEventTime(event, occurrence.StartsAt)
+}
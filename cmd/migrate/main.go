@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"rest-go-gin/internal/database"
 
 	"github.com/golang-migrate/migrate"
 	"github.com/golang-migrate/migrate/database/sqlite3"
@@ -26,6 +27,10 @@ func main() {
 
 	defer db.Close()
 
+	if err := database.CheckFTS5(db); err != nil {
+		log.Fatal(err)
+	}
+
 	instance, err := sqlite3.WithInstance(db, &sqlite3.Config{})
 
 	if err != nil {
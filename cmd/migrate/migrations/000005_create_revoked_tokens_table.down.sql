@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS session_revocations;
+DROP TABLE IF EXISTS revoked_tokens;
\ No newline at end of file
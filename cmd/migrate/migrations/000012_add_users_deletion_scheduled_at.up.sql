@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN deletion_scheduled_at DATETIME;
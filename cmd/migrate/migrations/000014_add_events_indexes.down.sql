@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_attendees_user_id;
+DROP INDEX IF EXISTS idx_events_owner_id;
+DROP INDEX IF EXISTS idx_events_date;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_events_date ON events (date, id);
+CREATE INDEX IF NOT EXISTS idx_events_owner_id ON events (owner_id);
+CREATE INDEX IF NOT EXISTS idx_attendees_user_id ON attendees (user_id);
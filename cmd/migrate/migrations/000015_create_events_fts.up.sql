@@ -0,0 +1,28 @@
+-- Requires SQLite with FTS5; build with -tags sqlite_fts5.
+CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(
+    name,
+    description,
+    location,
+    content = 'events',
+    content_rowid = 'id',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events BEGIN
+    INSERT INTO events_fts (rowid, name, description, location)
+    VALUES (new.id, new.name, new.description, new.location);
+END;
+
+CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN
+    INSERT INTO events_fts (events_fts, rowid, name, description, location)
+    VALUES ('delete', old.id, old.name, old.description, old.location);
+END;
+
+CREATE TRIGGER IF NOT EXISTS events_fts_update AFTER UPDATE OF name, description, location ON events BEGIN
+    INSERT INTO events_fts (events_fts, rowid, name, description, location)
+    VALUES ('delete', old.id, old.name, old.description, old.location);
+    INSERT INTO events_fts (rowid, name, description, location)
+    VALUES (new.id, new.name, new.description, new.location);
+END;
+
+INSERT INTO events_fts (events_fts) VALUES ('rebuild');
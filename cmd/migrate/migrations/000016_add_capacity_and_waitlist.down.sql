@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_attendees_event_id_status;
+
+ALTER TABLE attendees DROP COLUMN status;
+
+ALTER TABLE events DROP COLUMN capacity;
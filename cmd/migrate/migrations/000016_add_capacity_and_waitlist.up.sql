@@ -0,0 +1,5 @@
+ALTER TABLE events ADD COLUMN capacity INTEGER;
+
+ALTER TABLE attendees ADD COLUMN status TEXT NOT NULL DEFAULT 'confirmed';
+
+CREATE INDEX IF NOT EXISTS idx_attendees_event_id_status ON attendees (event_id, status, id);
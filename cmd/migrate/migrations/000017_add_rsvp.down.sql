@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_attendees_event_id_status;
+CREATE INDEX IF NOT EXISTS idx_attendees_event_id_status ON attendees (event_id, status, id);
+
+DELETE FROM attendees WHERE status = 'pending' OR rsvp = 'declined';
+
+ALTER TABLE attendees DROP COLUMN responded_at;
+ALTER TABLE attendees DROP COLUMN rsvp;
+
+ALTER TABLE events DROP COLUMN rsvp_policy;
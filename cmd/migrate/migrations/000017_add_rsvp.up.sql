@@ -0,0 +1,9 @@
+ALTER TABLE events ADD COLUMN rsvp_policy TEXT NOT NULL DEFAULT 'open';
+
+ALTER TABLE attendees ADD COLUMN rsvp TEXT NOT NULL DEFAULT 'going';
+ALTER TABLE attendees ADD COLUMN responded_at DATETIME;
+
+UPDATE attendees SET responded_at = CURRENT_TIMESTAMP;
+
+DROP INDEX IF EXISTS idx_attendees_event_id_status;
+CREATE INDEX IF NOT EXISTS idx_attendees_event_id_status ON attendees (event_id, status, responded_at, id);
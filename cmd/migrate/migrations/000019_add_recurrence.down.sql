@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_attendees_event_id_status;
+
+DELETE FROM attendees WHERE occurrence != '';
+ALTER TABLE attendees DROP COLUMN occurrence;
+
+CREATE INDEX IF NOT EXISTS idx_attendees_event_id_status ON attendees (event_id, status, responded_at, id);
+
+DROP TABLE IF EXISTS event_exceptions;
+
+ALTER TABLE events DROP COLUMN rrule;
@@ -0,0 +1,21 @@
+ALTER TABLE events ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS event_exceptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id INTEGER NOT NULL,
+    occurrence TEXT NOT NULL,
+    cancelled BOOLEAN NOT NULL DEFAULT 0,
+    date TEXT,
+    name TEXT,
+    description TEXT,
+    location TEXT,
+    UNIQUE (event_id, occurrence),
+    FOREIGN KEY (event_id) REFERENCES events (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_exceptions_date ON event_exceptions (date);
+
+ALTER TABLE attendees ADD COLUMN occurrence TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_attendees_event_id_status;
+CREATE INDEX IF NOT EXISTS idx_attendees_event_id_status ON attendees (event_id, occurrence, status, responded_at, id);
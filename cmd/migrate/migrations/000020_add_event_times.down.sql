@@ -0,0 +1,17 @@
+-- Events keep the day they start on in UTC; times and zones are lost.
+ALTER TABLE event_exceptions ADD COLUMN date TEXT;
+UPDATE event_exceptions SET date = date(starts_at) WHERE starts_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_event_exceptions_starts_at;
+ALTER TABLE event_exceptions DROP COLUMN ends_at;
+ALTER TABLE event_exceptions DROP COLUMN starts_at;
+CREATE INDEX IF NOT EXISTS idx_event_exceptions_date ON event_exceptions (date);
+
+ALTER TABLE events ADD COLUMN date DATETIME NOT NULL DEFAULT '';
+UPDATE events SET date = date(starts_at);
+
+DROP INDEX IF EXISTS idx_events_starts_at;
+ALTER TABLE events DROP COLUMN time_zone;
+ALTER TABLE events DROP COLUMN ends_at;
+ALTER TABLE events DROP COLUMN starts_at;
+CREATE INDEX IF NOT EXISTS idx_events_date ON events (date, id);
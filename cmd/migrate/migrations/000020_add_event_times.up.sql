@@ -0,0 +1,25 @@
+-- Events only had a day. Existing ones become all-day events in UTC, the
+-- zone their day was implicitly in. Times are stored in UTC.
+ALTER TABLE events ADD COLUMN starts_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN ends_at DATETIME NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN time_zone TEXT NOT NULL DEFAULT 'UTC';
+
+UPDATE events SET
+    starts_at = date(date) || ' 00:00:00+00:00',
+    ends_at = date(date, '+1 day') || ' 00:00:00+00:00';
+
+DROP INDEX IF EXISTS idx_events_date;
+ALTER TABLE events DROP COLUMN date;
+CREATE INDEX IF NOT EXISTS idx_events_starts_at ON events (starts_at, id);
+
+ALTER TABLE event_exceptions ADD COLUMN starts_at DATETIME;
+ALTER TABLE event_exceptions ADD COLUMN ends_at DATETIME;
+
+UPDATE event_exceptions SET
+    starts_at = date(date) || ' 00:00:00+00:00',
+    ends_at = date(date, '+1 day') || ' 00:00:00+00:00'
+WHERE date IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_event_exceptions_date;
+ALTER TABLE event_exceptions DROP COLUMN date;
+CREATE INDEX IF NOT EXISTS idx_event_exceptions_starts_at ON event_exceptions (starts_at);
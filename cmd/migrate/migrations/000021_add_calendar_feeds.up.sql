@@ -0,0 +1,17 @@
+ALTER TABLE events ADD COLUMN sequence INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE events ADD COLUMN updated_at DATETIME NOT NULL DEFAULT '';
+
+UPDATE events SET updated_at = strftime('%Y-%m-%d %H:%M:%S+00:00', 'now');
+
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME,
+    revoked_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_calendar_feeds_user_id ON calendar_feeds (user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_events_slug;
+ALTER TABLE events DROP COLUMN slug;
+ALTER TABLE events DROP COLUMN visibility;
@@ -0,0 +1,4 @@
+ALTER TABLE events ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+ALTER TABLE events ADD COLUMN slug TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_slug ON events (slug);
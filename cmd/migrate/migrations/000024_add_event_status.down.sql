@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_events_status;
+ALTER TABLE events DROP COLUMN cancellation_reason;
+ALTER TABLE events DROP COLUMN cancelled_at;
+ALTER TABLE events DROP COLUMN status;
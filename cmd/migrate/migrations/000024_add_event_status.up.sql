@@ -0,0 +1,7 @@
+-- Events created before statuses existed were already visible, so they
+-- start out published.
+ALTER TABLE events ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE events ADD COLUMN cancelled_at DATETIME;
+ALTER TABLE events ADD COLUMN cancellation_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_events_status ON events (status);
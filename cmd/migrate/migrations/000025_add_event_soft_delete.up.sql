@@ -0,0 +1,4 @@
+-- Deleted events are kept, hidden, until they are purged.
+ALTER TABLE events ADD COLUMN deleted_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_events_deleted_at ON events (deleted_at);
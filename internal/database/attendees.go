@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
@@ -10,120 +11,657 @@ type AttendeeModel struct {
 	DB *sql.DB
 }
 
+// Attendee statuses are the organizer's side of an attendance.
+const (
+	// AttendeeConfirmed attendees are admitted to the event.
+	AttendeeConfirmed = "confirmed"
+	// AttendeeWaitlisted attendees are going but wait for a seat.
+	AttendeeWaitlisted = "waitlisted"
+	// AttendeePending attendees wait for the owner to approve them.
+	AttendeePending = "pending"
+)
+
+// RSVP answers are the attendee's side of an attendance. Only confirmed
+// attendees who are going take up a seat.
+const (
+	RSVPGoing    = "going"
+	RSVPMaybe    = "maybe"
+	RSVPDeclined = "declined"
+)
+
+var (
+	ErrAttendeeExists = errors.New("attendee already exists")
+	ErrNotInvited     = errors.New("event is invite-only")
+)
+
+// attendeeColumns lists the columns scanned into an Attendee, in order, for
+// queries that alias attendees as a.
+const attendeeColumns = "a.id, a.user_id, a.event_id, a.occurrence, a.status, a.rsvp, a.responded_at"
+
+// liveAttendees selects the attendees whose account is not waiting to be
+// deleted. Only they hold seats and places on the waitlist, as only they
+// are listed; the others keep their answers and count again if their
+// account is restored.
+const liveAttendees = "user_id IN (SELECT id FROM " + liveUsers + ")"
+
 type Attendee struct {
-	Id      int `json:"id"`
-	UserId  int `json:"userId"`
-	EventId int `json:"eventId"`
+	Id      int    `json:"id"`
+	UserId  int    `json:"userId"`
+	EventId int    `json:"eventId"`
+	// Occurrence is the occurrence of a recurring event the attendance is
+	// for. It is empty for one-off events.
+	Occurrence string `json:"occurrence,omitempty"`
+	Status     string `json:"status"`
+	RSVP    string `json:"rsvp"`
+	// RespondedAt is when the attendee last changed their answer. The
+	// waitlist is promoted in this order.
+	RespondedAt time.Time `json:"respondedAt"`
+	// WaitlistPosition is 1 for the next attendee to be promoted. It is
+	// only set for waitlisted attendees.
+	WaitlistPosition *int `json:"waitlistPosition,omitempty"`
 }
 
-func (m *AttendeeModel) Insert(attendee *Attendee)(*Attendee, error){
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+// fields returns pointers to the fields in attendeeColumns order, for Scan.
+func (a *Attendee) fields() []interface{} {
+	return []interface{}{&a.Id, &a.UserId, &a.EventId, &a.Occurrence, &a.Status, &a.RSVP, &a.RespondedAt}
+}
+
+// EventAttendee is a user attending an event, as listed for the event.
+type EventAttendee struct {
+	Id               int    `json:"id"`
+	Name             string `json:"name"`
+	Email            string `json:"email,omitempty"`
+	Status           string `json:"status"`
+	RSVP             string `json:"rsvp"`
+	WaitlistPosition *int   `json:"waitlistPosition,omitempty"`
+}
+
+// Add inserts the attendee as going, confirming them if the event has room
+// and waitlisting them otherwise. The check and the insert run in one
+// transaction so concurrent requests cannot overbook the event.
+func (m *AttendeeModel) Add(attendee *Attendee) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO attendees (event_id, user_id) VALUES ($1, $2) RETURNING id"
-	err := m.DB.QueryRowContext(ctx, query, attendee.EventId, attendee.UserId).Scan(&attendee.Id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := getAttendee(ctx, tx, attendee.EventId, attendee.Occurrence, attendee.UserId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrAttendeeExists
+	}
 
+	attendee.RSVP = RSVPGoing
+	attendee.Status, err = admit(ctx, tx, attendee.EventId, attendee.Occurrence, attendee.RSVP)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return attendee,nil
+	if err := insertAttendee(ctx, tx, attendee); err != nil {
+		return err
+	}
+
+	if err := setWaitlistPosition(ctx, tx, attendee); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func(m *AttendeeModel) GetByEventAndAttendee(eventId, userId int)(*Attendee, error){
-	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
+// RSVP records the user's answer to an occurrence of the event, signing
+// them up if they are not an attendee yet. Who may sign up follows the
+// event's RSVP policy: with RSVPApproval new attendees are pending until the
+// owner approves them and with RSVPInviteOnly only existing attendees may
+// answer, otherwise ErrNotInvited is returned. Users admitted to another
+// occurrence of the event, or who accepted an invitation to it, may always
+// sign up.
+//
+// Answering going asks for a seat again, at the end of the waitlist if the
+// event is full. Giving up a seat promotes waitlisted attendees in the same
+// transaction; they are returned.
+func (m *AttendeeModel) RSVP(eventId int, occurrence string, userId int, rsvp string) (*Attendee, []*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT * FROM attendees where event_id = $1 AND user_id = $2"
-	
-	var attendee Attendee
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	attendee, promoted, err := respond(ctx, tx, eventId, occurrence, userId, rsvp, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attendee, promoted, tx.Commit()
+}
 
-	err := m.DB.QueryRowContext(ctx, query, eventId, userId).Scan(&attendee.Id, &attendee.UserId, &attendee.EventId)
+// respond records an answer as described for RSVP. Invited users skip the
+// event's RSVP policy: they are admitted right away, even if they were
+// pending.
+func respond(ctx context.Context, tx *sql.Tx, eventId int, occurrence string, userId int, rsvp string, invited bool) (*Attendee, []*Attendee, error) {
+	attendee, err := getAttendee(ctx, tx, eventId, occurrence, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if attendee != nil && attendee.RSVP == rsvp && !(invited && attendee.Status == AttendeePending) {
+		return attendee, nil, setWaitlistPosition(ctx, tx, attendee)
+	}
+
+	now := time.Now().UTC()
+
+	if attendee == nil {
+		var policy string
+		if err := tx.QueryRowContext(ctx, "SELECT rsvp_policy FROM events WHERE id = $1", eventId).Scan(&policy); err != nil {
+			return nil, nil, err
+		}
+		if invited {
+			policy = RSVPOpen
+		} else if policy != RSVPOpen {
+			member, err := isMember(ctx, tx, eventId, userId)
+			if err != nil {
+				return nil, nil, err
+			}
+			if member {
+				policy = RSVPOpen
+			}
+		}
+
+		attendee = &Attendee{EventId: eventId, Occurrence: occurrence, UserId: userId, RSVP: rsvp, RespondedAt: now}
+
+		switch policy {
+		case RSVPInviteOnly:
+			return nil, nil, ErrNotInvited
+		case RSVPApproval:
+			attendee.Status = AttendeePending
+		default:
+			if attendee.Status, err = admit(ctx, tx, eventId, occurrence, rsvp); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if err := insertAttendee(ctx, tx, attendee); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		attendee.RSVP = rsvp
+		attendee.RespondedAt = now
+
+		// The attendee holds no seat that admit would count: either they
+		// are pending or their old answer was not going.
+		if attendee.Status != AttendeePending || invited {
+			if attendee.Status, err = admit(ctx, tx, eventId, occurrence, rsvp); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		query := "UPDATE attendees SET status = $1, rsvp = $2, responded_at = $3 WHERE id = $4"
+		if _, err := tx.ExecContext(ctx, query, attendee.Status, attendee.RSVP, attendee.RespondedAt, attendee.Id); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	promoted, err := promoteWaitlisted(ctx, tx, eventId, occurrence)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := setWaitlistPosition(ctx, tx, attendee); err != nil {
+		return nil, nil, err
+	}
+
+	return attendee, promoted, nil
+}
+
+// Approve admits a pending attendee, confirming or waitlisting them as if
+// they had just signed up. Attendees who are not pending are returned
+// unchanged, and nil is returned if the user is not an attendee.
+func (m *AttendeeModel) Approve(eventId int, occurrence string, userId int) (*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	attendee, err := getAttendee(ctx, tx, eventId, occurrence, userId)
+	if err != nil || attendee == nil {
+		return nil, err
+	}
+
+	if attendee.Status == AttendeePending {
+		if attendee.Status, err = admit(ctx, tx, eventId, occurrence, attendee.RSVP); err != nil {
+			return nil, err
+		}
+
+		query := "UPDATE attendees SET status = $1 WHERE id = $2"
+		if _, err := tx.ExecContext(ctx, query, attendee.Status, attendee.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setWaitlistPosition(ctx, tx, attendee); err != nil {
+		return nil, err
+	}
+
+	return attendee, tx.Commit()
+}
+
+func getAttendee(ctx context.Context, db queryRower, eventId int, occurrence string, userId int) (*Attendee, error) {
+	query := "SELECT " + attendeeColumns + " FROM attendees a WHERE a.event_id = $1 AND a.occurrence = $2 AND a.user_id = $3"
+
+	var attendee Attendee
+	err := db.QueryRowContext(ctx, query, eventId, occurrence, userId).Scan(attendee.fields()...)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil,nil
+			return nil, nil
 		}
 		return nil, err
 	}
 
 	return &attendee, nil
+}
+
+func insertAttendee(ctx context.Context, tx *sql.Tx, attendee *Attendee) error {
+	if attendee.RespondedAt.IsZero() {
+		attendee.RespondedAt = time.Now().UTC()
+	}
 
+	query := "INSERT INTO attendees (event_id, occurrence, user_id, status, rsvp, responded_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+	return tx.QueryRowContext(ctx, query, attendee.EventId, attendee.Occurrence, attendee.UserId, attendee.Status, attendee.RSVP, attendee.RespondedAt).Scan(&attendee.Id)
 }
 
-func (m *AttendeeModel) GetAttendeesByEvent(eventId int)([]*User, error){
+// isMember reports whether the user was admitted to any occurrence of the
+// event or accepted an invitation to it.
+func isMember(ctx context.Context, tx *sql.Tx, eventId, userId int) (bool, error) {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM attendees WHERE event_id = $1 AND user_id = $2 AND status != $3)
+			OR EXISTS (SELECT 1 FROM invitations WHERE event_id = $1 AND user_id = $2 AND status = $4)
+	`
+
+	var member bool
+	err := tx.QueryRowContext(ctx, query, eventId, userId, AttendeePending, InvitationAccepted).Scan(&member)
+	return member, err
+}
+
+// admit returns the status of an admitted attendee with the given answer:
+// waitlisted if they are going and the event is full, confirmed otherwise.
+func admit(ctx context.Context, tx *sql.Tx, eventId int, occurrence string, rsvp string) (string, error) {
+	if rsvp != RSVPGoing {
+		return AttendeeConfirmed, nil
+	}
+
+	free, err := freeSeats(ctx, tx, eventId, occurrence)
+	if err != nil {
+		return "", err
+	}
+
+	if free == 0 {
+		return AttendeeWaitlisted, nil
+	}
+	return AttendeeConfirmed, nil
+}
+
+// setWaitlistPosition fills in the position of a waitlisted attendee.
+func setWaitlistPosition(ctx context.Context, tx *sql.Tx, attendee *Attendee) error {
+	attendee.WaitlistPosition = nil
+	if attendee.Status != AttendeeWaitlisted {
+		return nil
+	}
+
+	query := `
+		SELECT position FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY responded_at, id) AS position
+			FROM attendees WHERE event_id = $1 AND occurrence = $2 AND status = $3 AND ` + liveAttendees + `
+		) WHERE id = $4
+	`
+
+	// Attendees whose account waits to be deleted have no position.
+	var position int
+	err := tx.QueryRowContext(ctx, query, attendee.EventId, attendee.Occurrence, AttendeeWaitlisted, attendee.Id).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	attendee.WaitlistPosition = &position
+
+	return nil
+}
+
+func(m *AttendeeModel) GetByEventAndAttendee(eventId int, occurrence string, userId int)(*Attendee, error){
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
 
+	return getAttendee(ctx, m.DB, eventId, occurrence, userId)
+}
+
+// Attends reports whether the user attends any occurrence of the event,
+// which lets them see it whatever its visibility. Asking to join or
+// declining is not attending.
+func (m *AttendeeModel) Attends(eventId, userId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT EXISTS (SELECT 1 FROM attendees WHERE event_id = $1 AND user_id = $2 AND status != $3 AND rsvp != $4)"
+
+	var attends bool
+	err := m.DB.QueryRowContext(ctx, query, eventId, userId, AttendeePending, RSVPDeclined).Scan(&attends)
+	return attends, err
+}
+
+// GetAttendeesByEvent lists the attendees of an occurrence of the event:
+// confirmed attendees first, then the waitlist in the order it will be
+// promoted, then attendees awaiting approval.
+func (m *AttendeeModel) GetAttendeesByEvent(eventId int, occurrence string)([]*EventAttendee, error){
+	attendees := []*EventAttendee{}
+
+	err := m.EachAttendeeByEvent(eventId, occurrence, func(attendee *EventAttendee) error {
+		attendees = append(attendees, attendee)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attendees, nil
+}
+
+// EachAttendeeByEvent calls fn with every attendee of an occurrence of the
+// event, in the order of GetAttendeesByEvent, as they are read from the
+// database, and stops at the first error fn returns.
+func (m *AttendeeModel) EachAttendeeByEvent(eventId int, occurrence string, fn func(*EventAttendee) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
 	query := `
-		SELECT u.id, u.name, u.email
-		FROM users u
+		SELECT u.id, u.name, u.email, a.status, a.rsvp,
+			CASE WHEN a.status = $1 THEN ROW_NUMBER() OVER (PARTITION BY a.status ORDER BY a.responded_at, a.id) END
+		FROM ` + liveUsers + ` u
 		JOIN attendees a ON u.id = a.user_id
-		where a.event_id = $1
+		where a.event_id = $2 AND a.occurrence = $3
+		ORDER BY a.status = $1, a.status = $4, a.responded_at, a.id
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query, eventId)
+	rows, err := m.DB.QueryContext(ctx, query, AttendeeWaitlisted, eventId, occurrence, AttendeePending)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	defer rows.Close()
 
-	var users []*User
-
 	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.Id, &user.Name, &user.Email)
+		var attendee EventAttendee
+		err := rows.Scan(&attendee.Id, &attendee.Name, &attendee.Email, &attendee.Status, &attendee.RSVP, &attendee.WaitlistPosition)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		users = append(users, &user)
+		if err := fn(&attendee); err != nil {
+			return err
+		}
 	}
-	return users, nil
+
+	return rows.Err()
 }
 
-func (m *AttendeeModel) Delete(userId, eventId int) error {
+// Delete removes the attendee and, in the same transaction, promotes
+// waitlisted attendees into the seats that became free. It returns the
+// promoted attendees.
+func (m *AttendeeModel) Delete(userId, eventId int, occurrence string) ([]*Attendee, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "DELETE FROM attendees WHERE user_id = $1 AND event_id = $2"
-	_, err := m.DB.ExecContext(ctx, query, userId, eventId)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM attendees WHERE user_id = $1 AND event_id = $2 AND occurrence = $3"
+	_, err = tx.ExecContext(ctx, query, userId, eventId, occurrence)
 	if err != nil {
+		return nil, err
+	}
+
+	promoted, err := promoteWaitlisted(ctx, tx, eventId, occurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, tx.Commit()
+
+}
+
+// PromoteIntoSeatsOf fills the seats the user held in open events, once
+// their account waits to be deleted and so no longer holds them, from the
+// waitlists of those occurrences. It returns the promoted attendees.
+func (m *AttendeeModel) PromoteIntoSeatsOf(userId int) ([]*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT DISTINCT a.event_id, a.occurrence
+		FROM attendees a
+		JOIN ` + liveEvents + ` e ON e.id = a.event_id
+		WHERE a.user_id = $1 AND a.status = $2 AND a.rsvp = $3 AND e.status IN ($4, $5)
+	`
+	rows, err := tx.QueryContext(ctx, query, userId, AttendeeConfirmed, RSVPGoing, EventDraft, EventPublished)
+	if err != nil {
+		return nil, err
+	}
+
+	type seat struct {
+		eventId    int
+		occurrence string
+	}
+	var seats []seat
+	for rows.Next() {
+		var s seat
+		if err := rows.Scan(&s.eventId, &s.occurrence); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		seats = append(seats, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var promoted []*Attendee
+	for _, s := range seats {
+		p, err := promoteWaitlisted(ctx, tx, s.eventId, s.occurrence)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, p...)
+	}
+
+	return promoted, tx.Commit()
+}
+
+// waitlistRestored waitlists the user, whose account waits to be deleted
+// and is about to be restored, in the occurrences of open events that have
+// filled the seats they held.
+func waitlistRestored(ctx context.Context, tx *sql.Tx, userId int) error {
+	query := `
+		SELECT a.id, a.event_id, a.occurrence
+		FROM attendees a
+		JOIN ` + liveEvents + ` e ON e.id = a.event_id
+		WHERE a.user_id = $1 AND a.status = $2 AND a.rsvp = $3 AND e.status IN ($4, $5) AND e.capacity IS NOT NULL
+	`
+	rows, err := tx.QueryContext(ctx, query, userId, AttendeeConfirmed, RSVPGoing, EventDraft, EventPublished)
+	if err != nil {
+		return err
+	}
+
+	var seats []*Attendee
+	for rows.Next() {
+		var seat Attendee
+		if err := rows.Scan(&seat.Id, &seat.EventId, &seat.Occurrence); err != nil {
+			rows.Close()
+			return err
+		}
+		seats = append(seats, &seat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
 		return err
 	}
 
+	for _, seat := range seats {
+		free, err := freeSeats(ctx, tx, seat.EventId, seat.Occurrence)
+		if err != nil {
+			return err
+		}
+		if free != 0 {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE attendees SET status = $1 WHERE id = $2", AttendeeWaitlisted, seat.Id); err != nil {
+			return err
+		}
+	}
+
 	return nil
+}
+
+// freeSeats returns how many more attendees an occurrence of the event can
+// confirm, or -1 if its capacity is unlimited.
+func freeSeats(ctx context.Context, tx *sql.Tx, eventId int, occurrence string) (int, error) {
+	var capacity sql.NullInt64
+	if err := tx.QueryRowContext(ctx, "SELECT capacity FROM events WHERE id = $1", eventId).Scan(&capacity); err != nil {
+		return 0, err
+	}
+
+	if !capacity.Valid {
+		return -1, nil
+	}
+
+	var confirmed int
+	query := "SELECT COUNT(*) FROM attendees WHERE event_id = $1 AND occurrence = $2 AND status = $3 AND rsvp = $4 AND " + liveAttendees
+	if err := tx.QueryRowContext(ctx, query, eventId, occurrence, AttendeeConfirmed, RSVPGoing).Scan(&confirmed); err != nil {
+		return 0, err
+	}
+
+	return max(0, int(capacity.Int64)-confirmed), nil
+}
+
+// promoteWaitlisted confirms waitlisted attendees, longest waiting first,
+// until the occurrence is full or its waitlist is empty.
+func promoteWaitlisted(ctx context.Context, tx *sql.Tx, eventId int, occurrence string) ([]*Attendee, error) {
+	free, err := freeSeats(ctx, tx, eventId, occurrence)
+	if err != nil || free == 0 {
+		return nil, err
+	}
+
+	query := `
+		UPDATE attendees SET status = $1
+		WHERE id IN (SELECT id FROM attendees WHERE event_id = $2 AND occurrence = $3 AND status = $4 AND ` + liveAttendees + ` ORDER BY responded_at, id LIMIT $5)
+		RETURNING id, user_id, event_id, occurrence, status, rsvp, responded_at
+	`
+	rows, err := tx.QueryContext(ctx, query, AttendeeConfirmed, eventId, occurrence, AttendeeWaitlisted, free)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promoted []*Attendee
+	for rows.Next() {
+		var attendee Attendee
+		if err := rows.Scan(attendee.fields()...); err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, &attendee)
+	}
 
+	return promoted, rows.Err()
 }
 
-func (m *AttendeeModel) GetEventsByAttendee(attendeeId int)([]*Event, error){
+// GetEventsByAttendee returns the events matching the filter that the user
+// has not declined.
+func (m *AttendeeModel) GetEventsByAttendee(attendeeId int, filter EventFilter)([]*Event, error){
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
 
+	var b queryBuilder
+	b.conditions = append(b.conditions, "a.user_id = "+b.arg(attendeeId), "a.rsvp != "+b.arg(RSVPDeclined))
+	b.filterEvents(filter)
+
 	query := `
-	 SELECT e.id, e.owner_id, e.name, e.description, e.date, e.location
-	 FROM events e
-	 JOIN attendees a ON e.id = a.event_id
-	 WHERE a.user_id = $1
-	`
-	rows, err := m.DB.QueryContext(ctx, query, attendeeId)
+	 SELECT DISTINCT ` + eventColumns + `
+	 FROM ` + liveEvents + ` e
+	 JOIN attendees a ON e.id = a.event_id` + b.where()
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
 	if err != nil {
 		return nil,err
 	}
 
 	defer rows.Close()
 
-	var events []*Event
+	events := []*Event{}
 	for rows.Next(){
 		var event Event
-		err := rows.Scan(&event.Id, &event.OwnerId, &event.Name, &event.Description, &event.Date,&event.Location)
+		err := rows.Scan(event.fields()...)
 		if err != nil {
 			return nil,err
 		}
 		events = append(events, &event)
 	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 	
 	return events, nil
 
-}
\ No newline at end of file
+}
+// GetUsersByEvent returns the users who answered for any occurrence of the
+// event and did not decline, with only their ID, name and email set.
+func (m *AttendeeModel) GetUsersByEvent(eventId int) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT DISTINCT u.id, u.name, u.email
+		FROM ` + liveUsers + ` u
+		JOIN attendees a ON a.user_id = u.id
+		WHERE a.event_id = $1 AND a.rsvp != $2
+		ORDER BY u.id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, eventId, RSVPDeclined)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.Id, &user.Name, &user.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// AuditEntry records a security relevant action. ActorId is nil for actions
+// the system takes on its own, such as locking an account.
+type AuditEntry struct {
+	Id        int       `json:"id"`
+	ActorId   *int      `json:"actorId"`
+	Action    string    `json:"action"`
+	Subject   string    `json:"subject"`
+	IP        string    `json:"ip"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (m *AuditModel) Insert(entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	query := "INSERT INTO audit_log (actor_id, action, subject, ip, details, created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+	return m.DB.QueryRowContext(ctx, query, entry.ActorId, entry.Action, entry.Subject, entry.IP, entry.Details, entry.CreatedAt).Scan(&entry.Id)
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type CalendarFeedModel struct {
+	DB *sql.DB
+}
+
+// CalendarFeed lets calendar apps, which cannot send bearer tokens, read
+// the events of a user through a secret URL. Only the hash of the token in
+// the URL is stored.
+type CalendarFeed struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"userId"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+const calendarFeedColumns = "id, user_id, name, token_hash, created_at, last_used_at"
+
+func (f *CalendarFeed) fields() []interface{} {
+	return []interface{}{&f.Id, &f.UserId, &f.Name, &f.TokenHash, &f.CreatedAt, &f.LastUsedAt}
+}
+
+func (m *CalendarFeedModel) Insert(feed *CalendarFeed) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO calendar_feeds (user_id, name, token_hash, created_at) VALUES ($1, $2, $3, $4) RETURNING id"
+	return m.DB.QueryRowContext(ctx, query, feed.UserId, feed.Name, feed.TokenHash, feed.CreatedAt).Scan(&feed.Id)
+}
+
+// GetByHash returns the feed with the given token hash unless it has been
+// revoked.
+func (m *CalendarFeedModel) GetByHash(hash string) (*CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + calendarFeedColumns + " FROM calendar_feeds WHERE token_hash = $1 AND revoked_at IS NULL"
+
+	var feed CalendarFeed
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(feed.fields()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &feed, nil
+}
+
+// GetAllForUser returns the feeds of a user that have not been revoked.
+func (m *CalendarFeedModel) GetAllForUser(userId int) ([]*CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + calendarFeedColumns + " FROM calendar_feeds WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id"
+
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	feeds := []*CalendarFeed{}
+	for rows.Next() {
+		var feed CalendarFeed
+		if err := rows.Scan(feed.fields()...); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, &feed)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+// Revoke disables a feed of the given user. It reports false if the user has
+// no such active feed.
+func (m *CalendarFeedModel) Revoke(id int, userId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE calendar_feeds SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id, userId)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// RevokeAllForUser disables every active feeds of a user.
+func (m *CalendarFeedModel) RevokeAllForUser(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE calendar_feeds SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), userId)
+	return err
+}
+
+func (m *CalendarFeedModel) Touch(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE calendar_feeds SET last_used_at = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id)
+	return err
+}
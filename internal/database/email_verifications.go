@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type EmailVerificationModel struct {
+	DB *sql.DB
+}
+
+// EmailVerification is a single-use token proving that a user controls
+// Email. Only its hash is stored.
+type EmailVerification struct {
+	Id        int
+	UserId    int
+	Email     string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (m *EmailVerificationModel) Insert(verification *EmailVerification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO email_verification_tokens (user_id, email, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	return m.DB.QueryRowContext(ctx, query, verification.UserId, verification.Email, verification.TokenHash, verification.ExpiresAt, verification.CreatedAt).Scan(&verification.Id)
+}
+
+func (m *EmailVerificationModel) GetByHash(hash string) (*EmailVerification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, email, token_hash, expires_at, used_at, created_at FROM email_verification_tokens WHERE token_hash = $1"
+
+	var verification EmailVerification
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(&verification.Id, &verification.UserId, &verification.Email, &verification.TokenHash, &verification.ExpiresAt, &verification.UsedAt, &verification.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &verification, nil
+}
+
+// MarkUsed consumes the token. It reports false if the token had already
+// been used.
+func (m *EmailVerificationModel) MarkUsed(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE email_verification_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// InvalidateForUser consumes every outstanding token of a user.
+func (m *EmailVerificationModel) InvalidateForUser(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE email_verification_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), userId)
+	return err
+}
+
+// GetPendingEmail returns the address of an outstanding email change, or an
+// empty string if there is none. Tokens for the current address are not
+// counted.
+func (m *EmailVerificationModel) GetPendingEmail(userId int, currentEmail string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT email FROM email_verification_tokens WHERE user_id = $1 AND email <> $2 AND used_at IS NULL AND expires_at > $3 ORDER BY id DESC LIMIT 1"
+
+	var email string
+	err := m.DB.QueryRowContext(ctx, query, userId, currentEmail, time.Now().UTC()).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return email, nil
+}
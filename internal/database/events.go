@@ -2,10 +2,43 @@ package database
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"time"
 )
 
+// eventColumns lists the columns scanned into an Event, in order, for
+// queries that alias events as e.
+const eventColumns = "e.id, e.owner_id, e.name, e.description, e.starts_at, e.ends_at, e.time_zone, e.location, e.capacity, e.rsvp_policy, e.rrule, e.sequence, e.updated_at, e.visibility, e.slug, e.status, e.cancelled_at, e.cancellation_reason, e.deleted_at, " + eventTagsColumn
+
+// liveEvents stands in for the events table in queries: it leaves out
+// deleted events and those of accounts waiting to be deleted, so they are
+// hidden everywhere until they are restored or purged.
+const liveEvents = "(SELECT * FROM events WHERE deleted_at IS NULL AND owner_id IN (SELECT id FROM " + liveUsers + "))"
+
+// RSVP policies decide how users can sign up for an event themselves.
+const (
+	// RSVPOpen lets anybody sign up.
+	RSVPOpen = "open"
+	// RSVPApproval lets anybody ask to sign up; the owner approves them.
+	RSVPApproval = "approval"
+	// RSVPInviteOnly only lets users the owner added answer.
+	RSVPInviteOnly = "invite_only"
+)
+
+// Visibilities decide who can see an event.
+const (
+	// VisibilityPublic events are listed for everybody.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted events are only listed for their owner and
+	// attendees. Anybody with the link to their slug can see them.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPrivate events are only visible to their owner and
+	// attendees.
+	VisibilityPrivate = "private"
+)
+
 type EventModel struct {
 	DB *sql.DB
 }
@@ -14,24 +47,148 @@ type Event struct {
 	Id          int    `json:"id"`
 	OwnerId     int    `json:"ownerId"`
 	Name        string `json:"name" binding:"required,min=3"`
-	Description string `json:"description" binding:"required, min=10"`
-	Date        string `json:"date" binding:"required,datetime=2006-01-02"`
-	Location    string `json:"location" binding:"required,min=3"`
+	Description string `json:"description" binding:"required,min=10"`
+	// StartsAt and EndsAt are stored in UTC. TimeZone is the IANA zone the
+	// event takes place in; it defaults to UTC.
+	StartsAt time.Time `json:"startsAt" binding:"required"`
+	EndsAt   time.Time `json:"endsAt" binding:"required,gtfield=StartsAt"`
+	TimeZone string    `json:"timeZone" binding:"omitempty,timezone"`
+	Location string    `json:"location" binding:"required,min=3"`
+	// Capacity limits the number of confirmed attendees; further attendees
+	// are waitlisted. Nil means unlimited.
+	Capacity *int `json:"capacity,omitempty" binding:"omitempty,min=1"`
+	// RSVPPolicy is one of RSVPOpen, RSVPApproval and RSVPInviteOnly. It
+	// defaults to RSVPOpen.
+	RSVPPolicy string `json:"rsvpPolicy" binding:"omitempty,oneof=open approval invite_only"`
+	// RRule makes the event recur, as an RFC 5545 recurrence rule starting
+	// on the day of StartsAt in TimeZone. Occurrences keep the wall-clock
+	// times of the first one. Empty for one-off events.
+	RRule string `json:"rrule,omitempty"`
+	// Sequence counts the changes to the event, for calendar apps to tell
+	// versions apart. UpdatedAt is the time of the last one.
+	Sequence  int       `json:"sequence"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Visibility is one of VisibilityPublic, VisibilityUnlisted and
+	// VisibilityPrivate. It defaults to VisibilityPublic.
+	Visibility string `json:"visibility" binding:"omitempty,oneof=public unlisted private"`
+	// Slug is the secret that links to an unlisted event. Other events
+	// have none.
+	Slug *string `json:"slug,omitempty"`
+	// Status is where the event is in its lifecycle, one of EventDraft,
+	// EventPublished, EventCancelled and EventCompleted. It only changes
+	// through SetStatus. CancelledAt and CancellationReason are set once
+	// the event is cancelled.
+	Status             string     `json:"status"`
+	CancelledAt        *time.Time `json:"cancelledAt,omitempty"`
+	CancellationReason string     `json:"cancellationReason,omitempty"`
+	// DeletedAt is set once the event is deleted. It can be restored
+	// until it is purged.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Tags classify the event by topic.
+	Tags Tags `json:"tags" binding:"max=10,dive,min=1,max=30"`
+}
+
+// fields returns pointers to the fields in eventColumns order, for Scan.
+func (e *Event) fields() []interface{} {
+	return []interface{}{&e.Id, &e.OwnerId, &e.Name, &e.Description, &e.StartsAt, &e.EndsAt, &e.TimeZone, &e.Location, &e.Capacity, &e.RSVPPolicy, &e.RRule, &e.Sequence, &e.UpdatedAt, &e.Visibility, &e.Slug, &e.Status, &e.CancelledAt, &e.CancellationReason, &e.DeletedAt, &e.Tags}
+}
+
+// Zone returns the time zone the event takes place in.
+func (e *Event) Zone() *time.Location {
+	loc, err := time.LoadLocation(e.TimeZone)
+	if err != nil || e.TimeZone == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// In converts the start and end times to loc, or to the event's own time
+// zone if loc is nil.
+func (e *Event) In(loc *time.Location) {
+	if loc == nil {
+		loc = e.Zone()
+	}
+	e.StartsAt = e.StartsAt.In(loc)
+	e.EndsAt = e.EndsAt.In(loc)
+}
+
+// normalize applies defaults and drops what is not stored: times are kept
+// in UTC to the second, so they compare as text in SQL.
+func (e *Event) normalize() {
+	if e.RSVPPolicy == "" {
+		e.RSVPPolicy = RSVPOpen
+	}
+	if e.Visibility == "" {
+		e.Visibility = VisibilityPublic
+	}
+	if e.Status == "" {
+		e.Status = EventDraft
+	}
+	if e.Visibility != VisibilityUnlisted {
+		e.Slug = nil
+	}
+	if e.TimeZone == "" {
+		e.TimeZone = "UTC"
+	}
+	e.StartsAt = e.StartsAt.UTC().Truncate(time.Second)
+	e.EndsAt = e.EndsAt.UTC().Truncate(time.Second)
+	e.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	e.Tags = e.Tags.normalize()
+}
+
+// setSlug gives an unlisted event that has no slug yet a random one.
+func (e *Event) setSlug() error {
+	if e.Visibility != VisibilityUnlisted || e.Slug != nil {
+		return nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	slug := base64.RawURLEncoding.EncodeToString(b)
+	e.Slug = &slug
+	return nil
 }
- 
+
 func (m *EventModel) Insert(event *Event) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO events (owner_id, name, description, date,location) VALUES($1,$2,$3,$4,$5) RETURNING id"
-	return m.DB.QueryRowContext(ctx,query,event.OwnerId, event.Name, event.Description, event.Date, event.Location).Scan(&event.Id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertEvent(ctx context.Context, tx *sql.Tx, event *Event) error {
+	event.normalize()
+	event.Sequence = 0
+	if err := event.setSlug(); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO events (owner_id, name, description, starts_at, ends_at, time_zone, location, capacity, rsvp_policy, rrule, sequence, updated_at, visibility, slug, status) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) RETURNING id"
+	err := tx.QueryRowContext(ctx,query,event.OwnerId, event.Name, event.Description, event.StartsAt, event.EndsAt, event.TimeZone, event.Location, event.Capacity, event.RSVPPolicy, event.RRule, event.Sequence, event.UpdatedAt, event.Visibility, event.Slug, event.Status).Scan(&event.Id)
+	if err != nil {
+		return err
+	}
+
+	return setEventTags(ctx, tx, event.Id, event.Tags)
 }
 
 func (m *EventModel) GetAll()([]*Event, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT * FROM events"
+	query := "SELECT " + eventColumns + " FROM " + liveEvents + " e"
 
 	rows, err := m.DB.QueryContext(ctx,query)
 
@@ -46,7 +203,7 @@ func (m *EventModel) GetAll()([]*Event, error) {
 	for rows.Next() {
 		var event Event
 
-		err := rows.Scan(&event.Id, &event.OwnerId, &event.Name, &event.Description, &event.Date, &event.Location)
+		err := rows.Scan(event.fields()...)
 		if err != nil {
 			return nil, err
 		}
@@ -66,10 +223,10 @@ func (m *EventModel) Get(id int)(*Event, error){
 
 	defer cancel()
 
-	query := "SELECT * FROM events WHERE id = $1"
+	query := "SELECT " + eventColumns + " FROM " + liveEvents + " e WHERE e.id = $1"
 
 	var event Event
-	err := m.DB.QueryRowContext(ctx,query,id).Scan(&event.Id, &event.OwnerId, &event.Name, &event.Description, &event.Date, &event.Location)
+	err := m.DB.QueryRowContext(ctx,query,id).Scan(event.fields()...)
 
 	if err != nil {
 		if err == sql.ErrNoRows{
@@ -83,30 +240,185 @@ func (m *EventModel) Get(id int)(*Event, error){
 
 }
 
-func (m *EventModel) Update(event *Event) error {
+// GetBySlug returns the unlisted event with the slug, or nil if there is
+// none. Drafts are not returned.
+func (m *EventModel) GetBySlug(slug string) (*Event, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "UPDATE events SET name = $1, description = $2, date = $3, location = $4 WHERE id = $5"
-	_,err := m.DB.ExecContext(ctx, query, event.Name, event.Description, event.Date, event.Location, event.Id)
+	query := "SELECT " + eventColumns + " FROM " + liveEvents + " e WHERE e.slug = $1 AND e.visibility = $2 AND e.status != $3"
+
+	var event Event
+	err := m.DB.QueryRowContext(ctx, query, slug, VisibilityUnlisted, EventDraft).Scan(event.fields()...)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	return nil
+	return &event, nil
+}
+
+// Update saves the event and increases its sequence. If its capacity grew,
+// waitlisted attendees of every occurrence are promoted in the same
+// transaction; they are returned. Shrinking the capacity never removes
+// confirmed attendees.
+// The dates of a recurring event only change while nobody has answered for
+// them: see ErrScheduleInUse.
+func (m *EventModel) Update(event *Event) ([]*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	event.normalize()
+	if err := event.setSlug(); err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkSchedule(ctx, tx, event); err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE events SET name = $1, description = $2, starts_at = $3, ends_at = $4, time_zone = $5, location = $6, capacity = $7, rsvp_policy = $8, rrule = $9, sequence = sequence + 1, updated_at = $10, visibility = $11, slug = $12 WHERE id = $13 RETURNING sequence"
+	err = tx.QueryRowContext(ctx, query, event.Name, event.Description, event.StartsAt, event.EndsAt, event.TimeZone, event.Location, event.Capacity, event.RSVPPolicy, event.RRule, event.UpdatedAt, event.Visibility, event.Slug, event.Id).Scan(&event.Sequence)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setEventTags(ctx, tx, event.Id, event.Tags); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT occurrence FROM attendees WHERE event_id = $1 AND status = $2", event.Id, AttendeeWaitlisted)
+	if err != nil {
+		return nil, err
+	}
+
+	var occurrences []string
+	for rows.Next() {
+		var occurrence string
+		if err := rows.Scan(&occurrence); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var promoted []*Attendee
+	for _, occurrence := range occurrences {
+		p, err := promoteWaitlisted(ctx, tx, event.Id, occurrence)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, p...)
+	}
+
+	return promoted, tx.Commit()
 
 }
 
+// Delete marks the event deleted, which hides it together with its
+// attendees until it is restored or purged.
 func (m *EventModel) Delete(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "DELETE FROM events WHERE id = $1"
-	_,err := m.DB.ExecContext(ctx, query,id)
+	query := "UPDATE events SET deleted_at = $1 WHERE id = $2 AND deleted_at IS NULL"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC().Truncate(time.Second), id)
+	return err
+}
+
+// GetDeleted returns the deleted event with the ID, or nil if there is
+// none.
+func (m *EventModel) GetDeleted(id int) (*Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + eventColumns + " FROM events e WHERE e.id = $1 AND e.deleted_at IS NOT NULL"
+
+	var event Event
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(event.fields()...)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	return nil
+	return &event, nil
+}
+
+// Restore undeletes the event if it was deleted after since, and reports
+// whether it was.
+func (m *EventModel) Restore(event *Event, since time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE events SET deleted_at = NULL WHERE id = $1 AND deleted_at > $2"
+	result, err := m.DB.ExecContext(ctx, query, event.Id, since.UTC())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	event.DeletedAt = nil
+	return true, nil
+}
+
+// PurgeDeleted removes the events deleted before the given time for good,
+// together with their attendees, and returns how many were removed.
+func (m *EventModel) PurgeDeleted(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	query := "DELETE FROM events WHERE deleted_at IS NOT NULL AND deleted_at <= $1"
+	result, err := m.DB.ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
 
-}
\ No newline at end of file
+func (m *EventModel) GetAllByOwner(ownerId int) ([]*Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + eventColumns + " FROM " + liveEvents + " e WHERE e.owner_id = $1 ORDER BY e.starts_at, e.id"
+
+	rows, err := m.DB.QueryContext(ctx, query, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		var event Event
+		err := rows.Scan(event.fields()...)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,54 @@
+package database
+
+import "context"
+
+// ImportedEvent is an event to import, with the exceptions of its
+// occurrences and the users attending it.
+type ImportedEvent struct {
+	Event      *Event
+	Exceptions []*EventException
+	// AttendeeIds are the users going to a one-off event. They are
+	// waitlisted once the event is full.
+	AttendeeIds []int
+}
+
+// Import inserts the events with their exceptions and attendees in one
+// transaction, so either all of them are imported or none is. The events
+// are expected to be valid.
+func (m *EventModel) Import(events []*ImportedEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, imported := range events {
+		event := imported.Event
+		if err := insertEvent(ctx, tx, event); err != nil {
+			return err
+		}
+
+		for _, exception := range imported.Exceptions {
+			exception.EventId = event.Id
+			exception.normalize()
+			if err := setException(ctx, tx, exception); err != nil {
+				return err
+			}
+		}
+
+		for _, userId := range imported.AttendeeIds {
+			attendee := &Attendee{EventId: event.Id, UserId: userId, RSVP: RSVPGoing}
+			if attendee.Status, err = admit(ctx, tx, event.Id, "", attendee.RSVP); err != nil {
+				return err
+			}
+			if err := insertAttendee(ctx, tx, attendee); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
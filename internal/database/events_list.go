@@ -0,0 +1,336 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// eventSortColumns maps the names accepted in ?sort= to SQL expressions.
+var eventSortColumns = map[string]string{
+	"id":       "e.id",
+	"date":     "e.starts_at",
+	"name":     "e.name",
+	"location": "e.location",
+}
+
+type SortField struct {
+	Name string
+	Desc bool
+}
+
+// EventFilter narrows down an event listing. Zero values do not filter.
+type EventFilter struct {
+	// From and To limit the start time: From is inclusive, To exclusive.
+	From     time.Time
+	To       time.Time
+	Location string
+	OwnerId  int
+	// Query matches the name or description, case-insensitively.
+	Query string
+	// Tags matches events with any of the tags, or with all of them if
+	// MatchAllTags is set.
+	Tags         []string
+	MatchAllTags bool
+	// Statuses matches events with any of the statuses.
+	Statuses []string
+	// VisibleTo limits the events to those the user with this ID can see:
+	// public events and the events they own or attend, except for drafts,
+	// which only their owner sees. Zero stands for anonymous users, who
+	// only see public events. Nil does not limit.
+	VisibleTo *int
+}
+
+type EventListOptions struct {
+	EventFilter
+	Sort   []SortField
+	Limit  int
+	Cursor string
+}
+
+type EventPage struct {
+	Events     []*Event
+	NextCursor string
+	Total      int
+}
+
+// eventCursor points just past the last event of a page. It stores that
+// event's sort key, so the next page does not shift when events are added
+// or removed in between.
+type eventCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// ParseEventSort parses a sort parameter such as "date,-name". Fields are
+// ascending unless prefixed with "-". The id is always added as the last
+// field so the order is total, which keyset pagination relies on.
+func ParseEventSort(sort string) ([]SortField, error) {
+	if sort == "" {
+		sort = "date"
+	}
+
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		field := SortField{Name: strings.TrimPrefix(part, "-"), Desc: strings.HasPrefix(part, "-")}
+
+		if _, ok := eventSortColumns[field.Name]; !ok {
+			return nil, fmt.Errorf("cannot sort by %q", field.Name)
+		}
+		if seen[field.Name] {
+			return nil, fmt.Errorf("%q is sorted by twice", field.Name)
+		}
+		seen[field.Name] = true
+
+		fields = append(fields, field)
+	}
+
+	if !seen["id"] {
+		fields = append(fields, SortField{Name: "id"})
+	}
+
+	return fields, nil
+}
+
+func formatSort(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, field := range fields {
+		parts[i] = field.Name
+		if field.Desc {
+			parts[i] = "-" + field.Name
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// queryBuilder collects SQL conditions and numbers their placeholders.
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (b *queryBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) where() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conditions, " AND ")
+}
+
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+func (b *queryBuilder) filterEvents(filter EventFilter) {
+	if !filter.From.IsZero() {
+		b.conditions = append(b.conditions, "e.starts_at >= "+b.arg(filter.From.UTC()))
+	}
+	if !filter.To.IsZero() {
+		b.conditions = append(b.conditions, "e.starts_at < "+b.arg(filter.To.UTC()))
+	}
+	if filter.Location != "" {
+		b.conditions = append(b.conditions, `e.location LIKE `+b.arg(likePattern(filter.Location))+` ESCAPE '\'`)
+	}
+	if filter.OwnerId != 0 {
+		b.conditions = append(b.conditions, "e.owner_id = "+b.arg(filter.OwnerId))
+	}
+	if filter.Query != "" {
+		pattern := likePattern(filter.Query)
+		b.conditions = append(b.conditions, `(e.name LIKE `+b.arg(pattern)+` ESCAPE '\' OR e.description LIKE `+b.arg(pattern)+` ESCAPE '\')`)
+	}
+	if len(filter.Tags) > 0 {
+		b.filterTags(filter.Tags, filter.MatchAllTags)
+	}
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholders[i] = b.arg(status)
+		}
+		b.conditions = append(b.conditions, "e.status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.VisibleTo != nil {
+		b.filterVisibleTo(*filter.VisibleTo)
+	}
+}
+
+// filterVisibleTo adds the condition selecting the events the user can
+// see: public ones and those they own or attend, as Attends decides, but
+// only their own drafts.
+func (b *queryBuilder) filterVisibleTo(userId int) {
+	public := b.arg(VisibilityPublic)
+	user := b.arg(userId)
+	attending := "va.status != " + b.arg(AttendeePending) + " AND va.rsvp != " + b.arg(RSVPDeclined)
+	b.conditions = append(b.conditions, "(e.visibility = "+public+" OR e.owner_id = "+user+
+		" OR EXISTS (SELECT 1 FROM attendees va WHERE va.event_id = e.id AND va.user_id = "+user+" AND "+attending+"))")
+	b.conditions = append(b.conditions, "(e.status != "+b.arg(EventDraft)+" OR e.owner_id = "+user+")")
+}
+
+// after adds the keyset condition selecting the rows that sort after
+// values: (a > x) OR (a = x AND b > y) OR ..., with < for descending fields.
+func (b *queryBuilder) after(fields []SortField, values []string) {
+	var alternatives []string
+	for i, field := range fields {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, eventSortColumns[fields[j].Name]+" = "+b.arg(values[j]))
+		}
+
+		op := " > "
+		if field.Desc {
+			op = " < "
+		}
+		parts = append(parts, eventSortColumns[field.Name]+op+b.arg(values[i]))
+
+		alternatives = append(alternatives, "("+strings.Join(parts, " AND ")+")")
+	}
+	b.conditions = append(b.conditions, "("+strings.Join(alternatives, " OR ")+")")
+}
+
+// List returns one page of events matching the options, together with the
+// cursor of the next page and the number of matching events.
+func (m *EventModel) List(opts EventListOptions) (*EventPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sortKey := formatSort(opts.Sort)
+
+	var count queryBuilder
+	count.filterEvents(opts.EventFilter)
+
+	page := &EventPage{Events: []*Event{}}
+	if err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+liveEvents+" e"+count.where(), count.args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	var list queryBuilder
+	list.filterEvents(opts.EventFilter)
+
+	if opts.Cursor != "" {
+		cursor, err := decodeEventCursor(opts.Cursor)
+		if err != nil || cursor.Sort != sortKey || len(cursor.Values) != len(opts.Sort) {
+			return nil, ErrInvalidCursor
+		}
+		list.after(opts.Sort, cursor.Values)
+	}
+
+	// The sort keys are selected again as text so the cursor holds exactly
+	// what is stored, rather than the values as the driver converts them.
+	keys := make([]string, len(opts.Sort))
+	for i, field := range opts.Sort {
+		keys[i] = "CAST(" + eventSortColumns[field.Name] + " AS TEXT)"
+	}
+
+	query := "SELECT " + eventColumns + ", " + strings.Join(keys, ", ") +
+		" FROM " + liveEvents + " e" + list.where() +
+		orderBy(opts.Sort) +
+		" LIMIT " + list.arg(opts.Limit+1)
+
+	rows, err := m.DB.QueryContext(ctx, query, list.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var last []string
+	for rows.Next() {
+		var event Event
+		values := make([]string, len(opts.Sort))
+		dest := event.fields()
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		if len(page.Events) == opts.Limit {
+			page.NextCursor = encodeEventCursor(eventCursor{Sort: sortKey, Values: last})
+			break
+		}
+
+		page.Events = append(page.Events, &event)
+		last = values
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// Each calls fn with every event matching the filter, in the order of
+// sort, as they are read from the database, and stops at the first error
+// fn returns.
+func (m *EventModel) Each(filter EventFilter, sort []SortField, fn func(*Event) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	var b queryBuilder
+	b.filterEvents(filter)
+
+	query := "SELECT " + eventColumns + " FROM " + liveEvents + " e" + b.where() + orderBy(sort)
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(event.fields()...); err != nil {
+			return err
+		}
+
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func orderBy(sort []SortField) string {
+	order := make([]string, len(sort))
+	for i, field := range sort {
+		order[i] = eventSortColumns[field.Name]
+		if field.Desc {
+			order[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(order, ", ")
+}
+
+func encodeEventCursor(cursor eventCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeEventCursor(s string) (*eventCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor eventCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrEmptySearch = errors.New("search query has no terms")
+
+var ErrNoFTS5 = errors.New("SQLite is built without FTS5, which event search needs; build with -tags sqlite_fts5")
+
+// CheckFTS5 returns ErrNoFTS5 if the SQLite driver lacks FTS5. Once the
+// search migration has run, every write to events goes through its FTS5
+// triggers and fails without it, so binaries check this at startup.
+func CheckFTS5(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrNoFTS5
+	}
+	return nil
+}
+
+// Search results mark matches with these control characters, which cannot
+// appear in event text, so the text can be escaped before the marks become
+// HTML.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+type EventSearchResult struct {
+	*Event
+	// NameHighlight and Snippet are HTML: the event text is escaped and
+	// the matching terms are wrapped in <mark> elements.
+	NameHighlight string  `json:"nameHighlight"`
+	Snippet       string  `json:"snippet"`
+	Rank          float64 `json:"rank"`
+}
+
+type EventSearchPage struct {
+	Results []*EventSearchResult
+	Total   int
+}
+
+// BuildFTSQuery turns user input into an FTS5 query. Words are matched as
+// terms, a trailing * makes a word a prefix query and double quotes group
+// words into a phrase. Everything else is taken literally, so user input can
+// never produce an FTS5 syntax error. All terms have to match.
+func BuildFTSQuery(input string) (string, error) {
+	var terms []string
+
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+
+	rest := input
+	for {
+		start := strings.IndexByte(rest, '"')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[start+1:], '"')
+		if end < 0 {
+			rest = rest[:start] + " " + rest[start+1:]
+			break
+		}
+
+		phrase := strings.Join(words(rest[start+1:start+1+end]), " ")
+		if phrase != "" {
+			terms = append(terms, quote(phrase))
+		}
+		rest = rest[:start] + " " + rest[start+1+end+1:]
+	}
+
+	for _, field := range strings.Fields(rest) {
+		prefix := strings.HasSuffix(field, "*")
+		for _, word := range words(field) {
+			terms = append(terms, quote(word))
+		}
+		if prefix && len(terms) > 0 {
+			terms[len(terms)-1] += "*"
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", ErrEmptySearch
+	}
+
+	return strings.Join(terms, " "), nil
+}
+
+// words splits s the way the unicode61 tokenizer does, on anything that is
+// not a letter or digit.
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func markMatches(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>").Replace(s)
+}
+
+// Search returns events matching an FTS5 query built by BuildFTSQuery, best
+// matches first. Matches in the name weigh more than in the location, which
+// weigh more than in the description. Only events matching filter are
+// searched.
+func (m *EventModel) Search(query string, filter EventFilter, limit, offset int) (*EventSearchPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	page := &EventSearchPage{Results: []*EventSearchResult{}}
+
+	match := func(b *queryBuilder) {
+		b.conditions = append(b.conditions, "events_fts MATCH "+b.arg(query))
+		b.filterEvents(filter)
+	}
+
+	var count queryBuilder
+	match(&count)
+
+	err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM events_fts JOIN "+liveEvents+" e ON e.id = events_fts.rowid"+count.where(), count.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	var b queryBuilder
+	start, end := b.arg(matchStart), b.arg(matchEnd)
+	match(&b)
+
+	sqlQuery := `
+		SELECT ` + eventColumns + `,
+			highlight(events_fts, 0, ` + start + `, ` + end + `),
+			snippet(events_fts, 1, ` + start + `, ` + end + `, '…', 16),
+			bm25(events_fts, 10.0, 1.0, 5.0) AS rank
+		FROM events_fts
+		JOIN ` + liveEvents + ` e ON e.id = events_fts.rowid` + b.where() + `
+		ORDER BY rank, e.id
+		LIMIT ` + b.arg(limit) + ` OFFSET ` + b.arg(offset) + `
+	`
+
+	rows, err := m.DB.QueryContext(ctx, sqlQuery, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result := &EventSearchResult{Event: &Event{}}
+		event := result.Event
+		err := rows.Scan(append(event.fields(), &result.NameHighlight, &result.Snippet, &result.Rank)...)
+		if err != nil {
+			return nil, err
+		}
+
+		result.NameHighlight = markMatches(result.NameHighlight)
+		result.Snippet = markMatches(result.Snippet)
+		page.Results = append(page.Results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
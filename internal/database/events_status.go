@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Event statuses form a lifecycle: drafts are published, and published
+// events end up cancelled or completed.
+const (
+	// EventDraft events are being prepared. Only their owner and
+	// moderators can see them.
+	EventDraft = "draft"
+	// EventPublished events are visible and take RSVPs.
+	EventPublished = "published"
+	// EventCancelled events will not take place. Their attendees are kept.
+	EventCancelled = "cancelled"
+	// EventCompleted events have ended for good.
+	EventCompleted = "completed"
+)
+
+var ErrInvalidTransition = errors.New("invalid event status transition")
+
+// eventTransitions lists the statuses each status can change to.
+var eventTransitions = map[string][]string{
+	EventDraft:     {EventPublished},
+	EventPublished: {EventCancelled, EventCompleted},
+}
+
+// CanTransition reports whether an event can change from one status to
+// another.
+func CanTransition(from, to string) bool {
+	for _, status := range eventTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Open reports whether the event can still change and take attendees:
+// cancelled and completed events cannot.
+func (e *Event) Open() bool {
+	return e.Status == EventDraft || e.Status == EventPublished
+}
+
+// SetStatus moves the event to status and increases its sequence. reason
+// is kept for cancellations. ErrInvalidTransition is returned if the event
+// cannot change to status, including when it changed in the meantime.
+func (m *EventModel) SetStatus(event *Event, status, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if !CanTransition(event.Status, status) {
+		return ErrInvalidTransition
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var cancelledAt *time.Time
+	if status == EventCancelled {
+		cancelledAt = &now
+	} else {
+		reason = ""
+	}
+
+	query := `
+		UPDATE events SET status = $1, cancelled_at = $2, cancellation_reason = $3, sequence = sequence + 1, updated_at = $4
+		WHERE id = $5 AND status = $6
+		RETURNING sequence
+	`
+	err := m.DB.QueryRowContext(ctx, query, status, cancelledAt, reason, now, event.Id, event.Status).Scan(&event.Sequence)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidTransition
+	}
+	if err != nil {
+		return err
+	}
+
+	event.Status = status
+	event.CancelledAt = cancelledAt
+	event.CancellationReason = reason
+	event.UpdatedAt = now
+	return nil
+}
+
+// CompleteEnded marks published events completed once their last
+// occurrence has ended by now, and returns how many were. Recurring events
+// without COUNT or UNTIL never end.
+func (m *EventModel) CompleteEnded(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	// An event cannot have ended before its first occurrence did.
+	var b queryBuilder
+	b.conditions = append(b.conditions, "e.status = "+b.arg(EventPublished), "e.ends_at <= "+b.arg(now.UTC()))
+
+	rows, err := m.DB.QueryContext(ctx, "SELECT "+eventColumns+" FROM "+liveEvents+" e"+b.where(), b.args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var events []*Event
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(event.fields()...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, &event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	exceptions, err := m.getExceptions(ctx, &b)
+	if err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for _, event := range events {
+		ended, err := event.mayHaveEnded(now)
+		if err != nil {
+			return completed, err
+		}
+		if !ended {
+			continue
+		}
+
+		end, ok, err := event.lastEnd(exceptions[event.Id])
+		if err != nil {
+			return completed, err
+		}
+		if !ok || end.After(now) {
+			continue
+		}
+
+		result, err := m.DB.ExecContext(ctx, "UPDATE events SET status = $1, sequence = sequence + 1, updated_at = $2 WHERE id = $3 AND status = $4",
+			EventCompleted, now.UTC().Truncate(time.Second), event.Id, EventPublished)
+		if err != nil {
+			return completed, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			completed++
+		}
+	}
+
+	return completed, nil
+}
+
+// lastEnd returns when the last occurrence of the event ends, with the
+// exceptions applied, or false if the series goes on forever.
+func (e *Event) lastEnd(exceptions map[string]*EventException) (time.Time, bool, error) {
+	rule, err := e.Rule()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if rule == nil {
+		return e.EndsAt, true, nil
+	}
+
+	start := e.Day()
+	lastDay, ok := rule.Last(start)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	key := lastDay.Format("2006-01-02")
+	last := e.occurrence(lastDay, key, exceptions[key]).EndsAt
+
+	// Exceptions can move earlier occurrences past the last one.
+	for key, exception := range exceptions {
+		day, err := time.Parse("2006-01-02", key)
+		if err != nil || !day.Before(lastDay) || !rule.Occurs(start, day) {
+			continue
+		}
+		if o := e.occurrence(day, key, exception); o.EndsAt.After(last) {
+			last = o.EndsAt
+		}
+	}
+	return last, true, nil
+}
+
+// mayHaveEnded reports whether the event can have ended by now without
+// expanding its rule: series without COUNT or UNTIL never end, and those
+// with UNTIL not before its day.
+func (e *Event) mayHaveEnded(now time.Time) (bool, error) {
+	rule, err := e.Rule()
+	if err != nil || rule == nil {
+		return err == nil, err
+	}
+
+	if rule.Count == 0 && rule.Until.IsZero() {
+		return false, nil
+	}
+	return !rule.Until.After(dayIn(now, e.Zone())), nil
+}
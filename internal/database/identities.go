@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Identity links an account at an external OpenID Connect provider, named by
+// its issuer URL and subject, to a local user.
+type Identity struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"userId"`
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+func (m *IdentityModel) Insert(identity *Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO user_identities (user_id, issuer, subject, email, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	return m.DB.QueryRowContext(ctx, query, identity.UserId, identity.Issuer, identity.Subject, identity.Email, identity.CreatedAt).Scan(&identity.Id)
+}
+
+func (m *IdentityModel) Get(issuer, subject string) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, issuer, subject, email, created_at, last_login_at FROM user_identities WHERE issuer = $1 AND subject = $2"
+
+	var identity Identity
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(&identity.Id, &identity.UserId, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// RecordLogin stores the time of a login through the identity and the email
+// address the provider reported for it.
+func (m *IdentityModel) RecordLogin(id int, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE user_identities SET email = $1, last_login_at = $2 WHERE id = $3"
+	_, err := m.DB.ExecContext(ctx, query, email, time.Now().UTC(), id)
+	return err
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+var ErrInvitationClosed = errors.New("invitation is no longer pending")
+
+// Invitation asks somebody to attend an event. It is addressed to an email
+// address, so people without an account can be invited too.
+type Invitation struct {
+	Id        int    `json:"id"`
+	EventId   int    `json:"eventId"`
+	InviterId int    `json:"inviterId"`
+	Email     string `json:"email"`
+	// UserId is the invited user if the invitation was made for an account,
+	// or the user who accepted it.
+	UserId      *int       `json:"userId,omitempty"`
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RespondedAt *time.Time `json:"respondedAt,omitempty"`
+}
+
+const invitationColumns = "id, event_id, inviter_id, email, user_id, status, expires_at, created_at, responded_at"
+
+func (i *Invitation) fields() []interface{} {
+	return []interface{}{&i.Id, &i.EventId, &i.InviterId, &i.Email, &i.UserId, &i.Status, &i.ExpiresAt, &i.CreatedAt, &i.RespondedAt}
+}
+
+// Open reports whether the invitation can still be answered.
+func (i *Invitation) Open() bool {
+	return i.Status == InvitationPending && time.Now().Before(i.ExpiresAt)
+}
+
+func (m *InvitationModel) Insert(invitation *Invitation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	invitation.Status = InvitationPending
+
+	query := "INSERT INTO invitations (event_id, inviter_id, email, user_id, status, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	return m.DB.QueryRowContext(ctx, query, invitation.EventId, invitation.InviterId, invitation.Email, invitation.UserId, invitation.Status, invitation.ExpiresAt, invitation.CreatedAt).Scan(&invitation.Id)
+}
+
+func (m *InvitationModel) Get(id int) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + invitationColumns + " FROM invitations WHERE id = $1"
+
+	var invitation Invitation
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(invitation.fields()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// GetPendingByEvent returns the invitations of the event that can still be
+// answered, oldest first.
+func (m *InvitationModel) GetPendingByEvent(eventId int) ([]*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + invitationColumns + " FROM invitations WHERE event_id = $1 AND status = $2 AND expires_at > $3 ORDER BY created_at, id"
+
+	rows, err := m.DB.QueryContext(ctx, query, eventId, InvitationPending, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		if err := rows.Scan(invitation.fields()...); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// HasPending reports whether email already has an invitation to the event
+// that can still be answered.
+func (m *InvitationModel) HasPending(eventId int, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT EXISTS (SELECT 1 FROM invitations WHERE event_id = $1 AND email = $2 AND status = $3 AND expires_at > $4)"
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, eventId, email, InvitationPending, time.Now().UTC()).Scan(&exists)
+	return exists, err
+}
+
+// Accept marks the invitation accepted by the user and makes them an
+// attendee who is going to the occurrence, in one transaction. The event's
+// RSVP policy does not apply, but a full event still waitlists them.
+// Waitlisted attendees promoted along the way are returned too.
+// ErrInvitationClosed is returned if the invitation was answered, revoked or
+// expired in the meantime.
+func (m *InvitationModel) Accept(invitation *Invitation, occurrence string, userId int) (*Attendee, []*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := answerInvitation(ctx, tx, invitation, InvitationAccepted, &userId); err != nil {
+		return nil, nil, err
+	}
+
+	attendee, promoted, err := respond(ctx, tx, invitation.EventId, occurrence, userId, RSVPGoing, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attendee, promoted, tx.Commit()
+}
+
+// Decline marks the invitation declined. ErrInvitationClosed is returned if
+// it was answered, revoked or expired in the meantime.
+func (m *InvitationModel) Decline(invitation *Invitation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := answerInvitation(ctx, tx, invitation, InvitationDeclined, invitation.UserId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Revoke withdraws a pending invitation. It reports false if the
+// invitation was no longer pending.
+func (m *InvitationModel) Revoke(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE invitations SET status = $1 WHERE id = $2 AND status = $3"
+	result, err := m.DB.ExecContext(ctx, query, InvitationRevoked, id, InvitationPending)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func answerInvitation(ctx context.Context, tx *sql.Tx, invitation *Invitation, status string, userId *int) error {
+	now := time.Now().UTC()
+
+	query := "UPDATE invitations SET status = $1, user_id = $2, responded_at = $3 WHERE id = $4 AND status = $5 AND expires_at > $3"
+	result, err := tx.ExecContext(ctx, query, status, userId, now, invitation.Id, InvitationPending)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvitationClosed
+	}
+
+	invitation.Status = status
+	invitation.UserId = userId
+	invitation.RespondedAt = &now
+
+	return nil
+}
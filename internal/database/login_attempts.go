@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// LoginAttempt counts recent failed logins for a key such as an email
+// address or a client IP.
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+func (m *LoginAttemptModel) Get(key string) (*LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = $1"
+
+	var attempt LoginAttempt
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// RecordFailure adds a failure for key. Failures older than window are
+// forgotten, so the count starts over at one.
+func (m *LoginAttemptModel) RecordFailure(key string, window time.Duration) (*LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING key, failures, last_failure_at, locked_until
+	`
+
+	var attempt LoginAttempt
+	err := m.DB.QueryRowContext(ctx, query, key, now, now.Add(-window)).Scan(&attempt.Key, &attempt.Failures, &attempt.LastFailureAt, &attempt.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (m *LoginAttemptModel) Lock(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE login_attempts SET locked_until = $1 WHERE key = $2"
+	_, err := m.DB.ExecContext(ctx, query, until.UTC(), key)
+	return err
+}
+
+func (m *LoginAttemptModel) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// MFA is the TOTP enrollment of a user. It only protects logins once
+// ConfirmedAt is set.
+type MFA struct {
+	UserId       int
+	Secret       string
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+func (m *MFAModel) Get(userId int) (*MFA, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT user_id, secret, confirmed_at, last_used_step FROM user_mfa WHERE user_id = $1"
+
+	var mfa MFA
+	err := m.DB.QueryRowContext(ctx, query, userId).Scan(&mfa.UserId, &mfa.Secret, &mfa.ConfirmedAt, &mfa.LastUsedStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// Enroll stores a new, unconfirmed secret for the user, replacing any
+// earlier enrollment that was never confirmed.
+func (m *MFAModel) Enroll(userId int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed_at = NULL, last_used_step = 0
+	`
+	_, err := m.DB.ExecContext(ctx, query, userId, secret)
+	return err
+}
+
+// Confirm activates the enrollment and replaces the recovery codes in one
+// transaction.
+func (m *MFAModel) Confirm(userId int, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE user_mfa SET confirmed_at = $1, last_used_step = $2 WHERE user_id = $3"
+	if _, err := tx.ExecContext(ctx, query, time.Now().UTC(), step, userId); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that the code for step has been used. It reports false if
+// that step or a later one was already used, which makes every code single
+// use.
+func (m *MFAModel) UseStep(userId int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1"
+	result, err := m.DB.ExecContext(ctx, query, step, userId)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (m *MFAModel) ReplaceRecoveryCodes(userId int, hashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, hashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes an unused recovery code. It reports false if no
+// such code exists.
+func (m *MFAModel) UseRecoveryCode(userId int, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE mfa_recovery_codes SET used_at = $1 WHERE id = (SELECT id FROM mfa_recovery_codes WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL LIMIT 1)"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), userId, hash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// Delete removes the enrollment and all recovery codes of a user.
+func (m *MFAModel) Delete(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		query := "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+		if _, err := tx.ExecContext(ctx, query, userId, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
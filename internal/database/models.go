@@ -1,17 +1,48 @@
 package database
 
-import "database/sql"
+import (
+	"database/sql"
+	"time"
+)
+
+// bulkTimeout bounds imports and exports, which go through many rows at
+// once.
+const bulkTimeout = 30 * time.Second
 
 type Models struct {
-	Users     UserModel
-	Events    EventModel
-	Attendees AttendeeModel
+	Users          UserModel
+	Events         EventModel
+	Attendees      AttendeeModel
+	RefreshTokens  RefreshTokenModel
+	Revocations    RevocationModel
+	PasswordResets PasswordResetModel
+	Verifications  EmailVerificationModel
+	MFA            MFAModel
+	LoginAttempts  LoginAttemptModel
+	Audit          AuditModel
+	APIKeys        APIKeyModel
+	Identities     IdentityModel
+	Invitations    InvitationModel
+	CalendarFeeds  CalendarFeedModel
+	Tags           TagModel
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Users:     UserModel{DB: db},
-		Events:    EventModel{DB: db},
-		Attendees: AttendeeModel{DB: db},
+		Users:          UserModel{DB: db},
+		Events:         EventModel{DB: db},
+		Attendees:      AttendeeModel{DB: db},
+		RefreshTokens:  RefreshTokenModel{DB: db},
+		Revocations:    RevocationModel{DB: db},
+		PasswordResets: PasswordResetModel{DB: db},
+		Verifications:  EmailVerificationModel{DB: db},
+		MFA:            MFAModel{DB: db},
+		LoginAttempts:  LoginAttemptModel{DB: db},
+		Audit:          AuditModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		Identities:     IdentityModel{DB: db},
+		Invitations:    InvitationModel{DB: db},
+		CalendarFeeds:  CalendarFeedModel{DB: db},
+		Tags:           TagModel{DB: db},
 	}
 }
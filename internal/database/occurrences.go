@@ -0,0 +1,490 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rest-go-gin/internal/rrule"
+	"sort"
+	"time"
+)
+
+var ErrInvalidTimes = errors.New("endsAt must be after startsAt")
+
+// ErrScheduleInUse reports a change to the dates of a recurring event whose
+// occurrences are already attended or changed, which would leave those
+// answers and changes on dates that no longer occur.
+var ErrScheduleInUse = errors.New("the dates of an event with attendees or changed occurrences cannot be changed")
+
+// EventException overrides or cancels one occurrence of a recurring event.
+// Nil fields keep the value of the series. An occurrence moved with only
+// StartsAt keeps the duration of the series.
+type EventException struct {
+	EventId     int        `json:"eventId"`
+	Occurrence  string     `json:"occurrence"`
+	Cancelled   bool       `json:"cancelled"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+	EndsAt      *time.Time `json:"endsAt,omitempty"`
+	Name        *string    `json:"name,omitempty" binding:"omitempty,min=3"`
+	Description *string    `json:"description,omitempty" binding:"omitempty,min=10"`
+	Location    *string    `json:"location,omitempty" binding:"omitempty,min=3"`
+}
+
+// exceptionColumns lists the columns scanned into an EventException, in
+// order, for queries that alias event_exceptions as x.
+const exceptionColumns = "x.event_id, x.occurrence, x.cancelled, x.starts_at, x.ends_at, x.name, x.description, x.location"
+
+func (x *EventException) fields() []interface{} {
+	return []interface{}{&x.EventId, &x.Occurrence, &x.Cancelled, &x.StartsAt, &x.EndsAt, &x.Name, &x.Description, &x.Location}
+}
+
+// normalize keeps the times of the exception in UTC to the second, as
+// event times are stored.
+func (x *EventException) normalize() {
+	for _, t := range []*time.Time{x.StartsAt, x.EndsAt} {
+		if t != nil {
+			*t = t.UTC().Truncate(time.Second)
+		}
+	}
+}
+
+// Occurrence is one instance of an event, with any exception applied.
+type Occurrence struct {
+	Event
+	// Occurrence identifies the instance by the date the recurrence rule
+	// puts it on, which stays the same if the instance is moved. It is empty
+	// for one-off events.
+	Occurrence string `json:"occurrence,omitempty"`
+	// Cancelled is set for cancelled occurrences and for every occurrence
+	// of a cancelled event.
+	Cancelled bool `json:"cancelled"`
+	Modified  bool `json:"modified"`
+}
+
+// Rule returns the event's recurrence rule, or nil for a one-off event.
+func (e *Event) Rule() (*rrule.Rule, error) {
+	if e.RRule == "" {
+		return nil, nil
+	}
+	return rrule.ParseIn(e.RRule, e.Zone())
+}
+
+// Day returns the day the event, or the first occurrence of a recurring
+// event, starts on in its time zone, as midnight UTC of that date.
+func (e *Event) Day() time.Time {
+	return dayIn(e.StartsAt, e.Zone())
+}
+
+// checkSchedule returns ErrScheduleInUse if saving event would change the
+// dates its stored version occurs on while attendees or exceptions refer to
+// them by date.
+func checkSchedule(ctx context.Context, tx *sql.Tx, event *Event) error {
+	var stored Event
+	query := "SELECT starts_at, time_zone, rrule FROM events WHERE id = $1"
+	if err := tx.QueryRowContext(ctx, query, event.Id).Scan(&stored.StartsAt, &stored.TimeZone, &stored.RRule); err != nil {
+		return err
+	}
+
+	if stored.RRule == event.RRule && (event.RRule == "" || stored.Day().Equal(event.Day())) {
+		return nil
+	}
+
+	var used bool
+	query = "SELECT EXISTS (SELECT 1 FROM attendees WHERE event_id = $1) OR EXISTS (SELECT 1 FROM event_exceptions WHERE event_id = $1)"
+	if err := tx.QueryRowContext(ctx, query, event.Id).Scan(&used); err != nil {
+		return err
+	}
+
+	if used {
+		return ErrScheduleInUse
+	}
+	return nil
+}
+
+// dayIn returns the date t falls on in loc, as midnight UTC of that date.
+func dayIn(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// occurrence returns the occurrence of the event on day. It starts and ends
+// at the same wall-clock times as the first one, in the event's time zone.
+func (e *Event) occurrence(day time.Time, key string, exception *EventException) *Occurrence {
+	occurrence := &Occurrence{Event: *e, Occurrence: key, Cancelled: e.Status == EventCancelled}
+
+	days := int(day.Sub(e.Day()).Hours() / 24)
+	loc := e.Zone()
+	occurrence.StartsAt = e.StartsAt.In(loc).AddDate(0, 0, days)
+	occurrence.EndsAt = e.EndsAt.In(loc).AddDate(0, 0, days)
+
+	if exception != nil {
+		occurrence.Cancelled = occurrence.Cancelled || exception.Cancelled
+		occurrence.Modified = exception.StartsAt != nil || exception.EndsAt != nil || exception.Name != nil || exception.Description != nil || exception.Location != nil
+		if exception.StartsAt != nil {
+			occurrence.EndsAt = exception.StartsAt.Add(occurrence.EndsAt.Sub(occurrence.StartsAt))
+			occurrence.StartsAt = *exception.StartsAt
+		}
+		if exception.EndsAt != nil {
+			occurrence.EndsAt = *exception.EndsAt
+		}
+		if exception.Name != nil {
+			occurrence.Name = *exception.Name
+		}
+		if exception.Description != nil {
+			occurrence.Description = *exception.Description
+		}
+		if exception.Location != nil {
+			occurrence.Location = *exception.Location
+		}
+	}
+
+	return occurrence
+}
+
+// expand returns the occurrences of the event that start at or after from
+// and before to. Moved occurrences count by the time they were moved to.
+func (e *Event) expand(from, to time.Time, exceptions map[string]*EventException) ([]*Occurrence, error) {
+	inWindow := func(o *Occurrence) bool {
+		return !o.StartsAt.Before(from) && o.StartsAt.Before(to)
+	}
+
+	start := e.Day()
+
+	rule, err := e.Rule()
+	if err != nil {
+		return nil, err
+	}
+
+	if rule == nil {
+		if o := e.occurrence(start, "", nil); inWindow(o) {
+			return []*Occurrence{o}, nil
+		}
+		return nil, nil
+	}
+
+	var occurrences []*Occurrence
+	seen := map[string]bool{}
+	loc := e.Zone()
+	for _, day := range rule.Between(start, dayIn(from, loc), dayIn(to, loc)) {
+		key := day.Format("2006-01-02")
+		seen[key] = true
+
+		if o := e.occurrence(day, key, exceptions[key]); inWindow(o) {
+			occurrences = append(occurrences, o)
+		}
+	}
+
+	// Occurrences moved into the window from outside of it.
+	for key, exception := range exceptions {
+		if seen[key] || exception.StartsAt == nil {
+			continue
+		}
+
+		day, err := time.Parse("2006-01-02", key)
+		if err != nil || !rule.Occurs(start, day) {
+			continue
+		}
+
+		if o := e.occurrence(day, key, exception); inWindow(o) {
+			occurrences = append(occurrences, o)
+		}
+	}
+
+	return occurrences, nil
+}
+
+// Occurrences returns the occurrences of events matching the filter that
+// start between its From and To times, which are both required, ordered by
+// start time. Recurring events are expanded and cancelled occurrences are
+// included. If eventId is not 0 only that event is expanded.
+func (m *EventModel) Occurrences(filter EventFilter, eventId int) ([]*Occurrence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if filter.From.IsZero() || filter.To.IsZero() {
+		return nil, errors.New("occurrences need a window")
+	}
+	from, to := filter.From, filter.To
+
+	// The window is applied to occurrences below, not to the start of
+	// the series.
+	window := filter
+	window.From, window.To = time.Time{}, time.Time{}
+
+	var b queryBuilder
+	b.filterEvents(window)
+	if eventId != 0 {
+		b.conditions = append(b.conditions, "e.id = "+b.arg(eventId))
+	}
+
+	fromArg, toArg := b.arg(from.UTC()), b.arg(to.UTC())
+	b.conditions = append(b.conditions, fmt.Sprintf(`(
+		(e.rrule = '' AND e.starts_at >= %[1]s AND e.starts_at < %[2]s)
+		OR (e.rrule != '' AND e.starts_at < %[2]s)
+		OR EXISTS (SELECT 1 FROM event_exceptions x WHERE x.event_id = e.id AND x.starts_at >= %[1]s AND x.starts_at < %[2]s)
+	)`, fromArg, toArg))
+
+	rows, err := m.DB.QueryContext(ctx, "SELECT "+eventColumns+" FROM "+liveEvents+" e"+b.where(), b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		var event Event
+		if err := rows.Scan(event.fields()...); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	exceptions, err := m.getExceptions(ctx, &b)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := []*Occurrence{}
+	for _, event := range events {
+		expanded, err := event.expand(from, to, exceptions[event.Id])
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, expanded...)
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		a, b := occurrences[i], occurrences[j]
+		if !a.StartsAt.Equal(b.StartsAt) {
+			return a.StartsAt.Before(b.StartsAt)
+		}
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		return a.Occurrence < b.Occurrence
+	})
+
+	return occurrences, nil
+}
+
+// getExceptions returns the exceptions of the events the builder selects,
+// by event and occurrence.
+func (m *EventModel) getExceptions(ctx context.Context, b *queryBuilder) (map[int]map[string]*EventException, error) {
+	exceptions := map[int]map[string]*EventException{}
+
+	query := "SELECT " + exceptionColumns + " FROM event_exceptions x JOIN " + liveEvents + " e ON e.id = x.event_id" + b.where()
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var exception EventException
+		if err := rows.Scan(exception.fields()...); err != nil {
+			return nil, err
+		}
+		if exceptions[exception.EventId] == nil {
+			exceptions[exception.EventId] = map[string]*EventException{}
+		}
+		exceptions[exception.EventId][exception.Occurrence] = &exception
+	}
+
+	return exceptions, rows.Err()
+}
+
+// GetOccurrence returns the occurrence of a recurring event identified by
+// the date the rule puts it on, or nil if the rule has no such occurrence.
+func (m *EventModel) GetOccurrence(event *Event, key string) (*Occurrence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rule, err := event.Rule()
+	if err != nil || rule == nil {
+		return nil, err
+	}
+
+	day, err := time.Parse("2006-01-02", key)
+	if err != nil || !rule.Occurs(event.Day(), day) {
+		return nil, nil
+	}
+
+	query := "SELECT " + exceptionColumns + " FROM event_exceptions x WHERE x.event_id = $1 AND x.occurrence = $2"
+
+	var exception EventException
+	err = m.DB.QueryRowContext(ctx, query, event.Id, key).Scan(exception.fields()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return event.occurrence(day, key, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return event.occurrence(day, key, &exception), nil
+}
+
+// SetException stores the exception of an occurrence of the event,
+// replacing an earlier one, increases the event's sequence and returns the
+// occurrence as it becomes. ErrInvalidTimes is returned if the occurrence
+// would end before it starts.
+func (m *EventModel) SetException(event *Event, exception *EventException) (*Occurrence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	day, err := time.Parse("2006-01-02", exception.Occurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	exception.normalize()
+
+	occurrence := event.occurrence(day, exception.Occurrence, exception)
+	if !occurrence.EndsAt.After(occurrence.StartsAt) {
+		return nil, ErrInvalidTimes
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setException(ctx, tx, exception); err != nil {
+		return nil, err
+	}
+
+	if err := touchEvent(ctx, tx, event); err != nil {
+		return nil, err
+	}
+	occurrence.Sequence, occurrence.UpdatedAt = event.Sequence, event.UpdatedAt
+
+	return occurrence, tx.Commit()
+}
+
+func setException(ctx context.Context, tx *sql.Tx, exception *EventException) error {
+	query := `
+		INSERT INTO event_exceptions (event_id, occurrence, cancelled, starts_at, ends_at, name, description, location)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (event_id, occurrence) DO UPDATE SET
+			cancelled = excluded.cancelled, starts_at = excluded.starts_at, ends_at = excluded.ends_at,
+			name = excluded.name, description = excluded.description, location = excluded.location
+	`
+	_, err := tx.ExecContext(ctx, query, exception.EventId, exception.Occurrence, exception.Cancelled,
+		exception.StartsAt, exception.EndsAt, exception.Name, exception.Description, exception.Location)
+	return err
+}
+
+// DeleteException restores an occurrence of the event to what the series
+// says and increases the event's sequence. It reports false if the
+// occurrence had no exception.
+func (m *EventModel) DeleteException(event *Event, occurrence string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM event_exceptions WHERE event_id = $1 AND occurrence = $2", event.Id, occurrence)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := touchEvent(ctx, tx, event); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// touchEvent increases the sequence of an event whose occurrences changed.
+func touchEvent(ctx context.Context, tx *sql.Tx, event *Event) error {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	query := "UPDATE events SET sequence = sequence + 1, updated_at = $1 WHERE id = $2 RETURNING sequence"
+	if err := tx.QueryRowContext(ctx, query, now, event.Id).Scan(&event.Sequence); err != nil {
+		return err
+	}
+
+	event.UpdatedAt = now
+	return nil
+}
+
+// Exceptions returns the occurrences of a recurring event that have an
+// exception, in order. Exceptions of dates the rule no longer puts an
+// occurrence on are left out.
+func (m *EventModel) Exceptions(event *Event) ([]*Occurrence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rule, err := event.Rule()
+	if err != nil || rule == nil {
+		return nil, err
+	}
+
+	query := "SELECT " + exceptionColumns + " FROM event_exceptions x WHERE x.event_id = $1 ORDER BY x.occurrence"
+	rows, err := m.DB.QueryContext(ctx, query, event.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var occurrences []*Occurrence
+	for rows.Next() {
+		var exception EventException
+		if err := rows.Scan(exception.fields()...); err != nil {
+			return nil, err
+		}
+
+		day, err := time.Parse("2006-01-02", exception.Occurrence)
+		if err != nil || !rule.Occurs(event.Day(), day) {
+			continue
+		}
+		occurrences = append(occurrences, event.occurrence(day, exception.Occurrence, &exception))
+	}
+
+	return occurrences, rows.Err()
+}
+
+// ScheduledStart returns when the series puts the occurrence identified by
+// key, leaving exceptions aside.
+func (e *Event) ScheduledStart(key string) (time.Time, error) {
+	day, err := time.Parse("2006-01-02", key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return e.occurrence(day, key, nil).StartsAt, nil
+}
+
+// OccurrenceAt returns the key of the occurrence the series puts at start,
+// the reverse of ScheduledStart. It reports false if the series has no
+// occurrence starting then.
+func (e *Event) OccurrenceAt(start time.Time) (string, bool) {
+	rule, err := e.Rule()
+	if err != nil || rule == nil {
+		return "", false
+	}
+
+	day := dayIn(start, e.Zone())
+	if !rule.Occurs(e.Day(), day) {
+		return "", false
+	}
+
+	key := day.Format("2006-01-02")
+	if !e.occurrence(day, key, nil).StartsAt.Equal(start) {
+		return "", false
+	}
+	return key, true
+}
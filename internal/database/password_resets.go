@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// PasswordReset is a single-use password reset token. Only its hash is
+// stored.
+type PasswordReset struct {
+	Id        int
+	UserId    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (m *PasswordResetModel) Insert(reset *PasswordReset) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4) RETURNING id"
+	return m.DB.QueryRowContext(ctx, query, reset.UserId, reset.TokenHash, reset.ExpiresAt, reset.CreatedAt).Scan(&reset.Id)
+}
+
+func (m *PasswordResetModel) GetByHash(hash string) (*PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, token_hash, expires_at, used_at, created_at FROM password_reset_tokens WHERE token_hash = $1"
+
+	var reset PasswordReset
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(&reset.Id, &reset.UserId, &reset.TokenHash, &reset.ExpiresAt, &reset.UsedAt, &reset.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+// MarkUsed consumes the token. It reports false if the token had already
+// been used, so two concurrent requests cannot both reset the password.
+func (m *PasswordResetModel) MarkUsed(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE password_reset_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// InvalidateForUser consumes every outstanding token of a user.
+func (m *PasswordResetModel) InvalidateForUser(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), userId)
+	return err
+}
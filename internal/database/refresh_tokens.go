@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned by Rotate when the token being rotated
+// has already been used or revoked.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+type RefreshTokenModel struct {
+	DB *sql.DB
+}
+
+// RefreshToken is a server-side record of an opaque refresh token. Only the
+// hash of the token is stored; every token issued from the same login shares
+// a FamilyId so the whole chain can be revoked at once.
+type RefreshToken struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"userId"`
+	FamilyId  string     `json:"familyId"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+func (m *RefreshTokenModel) Insert(token *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertRefreshToken(ctx, m.DB, token)
+}
+
+func (m *RefreshTokenModel) GetByHash(hash string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, created_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	var token RefreshToken
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(&token.Id, &token.UserId, &token.FamilyId, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.RevokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Rotate revokes current and stores next in a single transaction. If current
+// was already revoked, nothing is written and ErrRefreshTokenReused is
+// returned so the caller can treat it as a replay.
+func (m *RefreshTokenModel) Rotate(current, next *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL"
+	result, err := tx.ExecContext(ctx, query, time.Now().UTC(), current.Id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *RefreshTokenModel) RevokeFamily(familyId string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), familyId)
+	return err
+}
+
+func (m *RefreshTokenModel) RevokeAllForUser(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), userId)
+	return err
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func insertRefreshToken(ctx context.Context, db queryRower, token *RefreshToken) error {
+	query := "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	return db.QueryRowContext(ctx, query, token.UserId, token.FamilyId, token.TokenHash, token.ExpiresAt, token.CreatedAt).Scan(&token.Id)
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// RevokedToken is a single access token, identified by its jti claim, that
+// must be rejected until it would have expired anyway.
+type RevokedToken struct {
+	Jti       string
+	UserId    int
+	ExpiresAt time.Time
+}
+
+// SessionRevocation invalidates every access token issued to a user before
+// RevokedBefore.
+type SessionRevocation struct {
+	UserId        int
+	RevokedBefore time.Time
+}
+
+func (m *RevocationModel) RevokeToken(token *RevokedToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT OR IGNORE INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3)"
+	_, err := m.DB.ExecContext(ctx, query, token.Jti, token.UserId, token.ExpiresAt)
+	return err
+}
+
+func (m *RevocationModel) RevokeSessions(revocation *SessionRevocation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO session_revocations (user_id, revoked_before) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = excluded.revoked_before
+	`
+	_, err := m.DB.ExecContext(ctx, query, revocation.UserId, revocation.RevokedBefore)
+	return err
+}
+
+// GetActiveTokens returns the revoked tokens that have not yet expired.
+func (m *RevocationModel) GetActiveTokens(now time.Time) ([]*RevokedToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT jti, user_id, expires_at FROM revoked_tokens WHERE expires_at > $1"
+	rows, err := m.DB.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*RevokedToken
+	for rows.Next() {
+		var token RevokedToken
+		if err := rows.Scan(&token.Jti, &token.UserId, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (m *RevocationModel) GetSessionRevocations() ([]*SessionRevocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, "SELECT user_id, revoked_before FROM session_revocations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revocations []*SessionRevocation
+	for rows.Next() {
+		var revocation SessionRevocation
+		if err := rows.Scan(&revocation.UserId, &revocation.RevokedBefore); err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, &revocation)
+	}
+
+	return revocations, rows.Err()
+}
+
+func (m *RevocationModel) DeleteExpiredTokens(now time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at <= $1", now)
+	return err
+}
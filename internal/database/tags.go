@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// eventTagsColumn selects the tags of the event aliased e as a comma
+// separated list, which Tags scans.
+const eventTagsColumn = "(SELECT group_concat(t.name) FROM event_tags et JOIN tags t ON t.id = et.tag_id WHERE et.event_id = e.id)"
+
+// tagPattern matches tag names: words of letters and digits joined by
+// hyphens. They cannot contain commas, which separate tags in filters and
+// in eventTagsColumn.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}]+(-[\p{L}\p{N}]+)*$`)
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// Tag is a tag with the number of events that have it.
+type Tag struct {
+	Name   string `json:"name"`
+	Events int    `json:"events"`
+}
+
+// Tags are the tag names of an event, normalized and sorted.
+type Tags []string
+
+// Scan reads the list eventTagsColumn selects.
+func (t *Tags) Scan(src interface{}) error {
+	var list string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		list = v
+	case []byte:
+		list = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", src)
+	}
+
+	*t = Tags{}
+	if list != "" {
+		*t = strings.Split(list, ",")
+		sort.Strings(*t)
+	}
+	return nil
+}
+
+// normalize returns the tags normalized, without duplicates, sorted.
+func (t Tags) normalize() Tags {
+	tags := Tags{}
+	seen := map[string]bool{}
+	for _, name := range t {
+		name = NormalizeTag(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// NormalizeTag returns a tag name the way it is stored: trimmed, in lower
+// case, and with spaces replaced by hyphens.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), "-"))
+}
+
+// ValidTag reports whether a normalized tag name consists of letters and
+// digits joined by hyphens.
+func ValidTag(name string) bool {
+	return tagPattern.MatchString(name)
+}
+
+// setEventTags replaces the tags of the event, creating tags that do not
+// exist yet.
+func setEventTags(ctx context.Context, tx *sql.Tx, eventId int, tags Tags) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM event_tags WHERE event_id = $1", eventId); err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO tags (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", name); err != nil {
+			return err
+		}
+
+		query := "INSERT INTO event_tags (event_id, tag_id) SELECT $1, id FROM tags WHERE name = $2"
+		if _, err := tx.ExecContext(ctx, query, eventId, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filterTags adds the condition selecting events with any of the tags, or
+// with all of them if all is set.
+func (b *queryBuilder) filterTags(tags []string, all bool) {
+	placeholders := make([]string, len(tags))
+	for i, tag := range tags {
+		placeholders[i] = b.arg(tag)
+	}
+
+	query := "SELECT et.event_id FROM event_tags et JOIN tags t ON t.id = et.tag_id WHERE t.name IN (" + strings.Join(placeholders, ", ") + ")"
+	if all {
+		query += " GROUP BY et.event_id HAVING COUNT(*) = " + b.arg(len(tags))
+	}
+
+	b.conditions = append(b.conditions, "e.id IN ("+query+")")
+}
+
+// GetAll returns the tags that events matching the filter have, the most
+// used first. Only those events are counted.
+func (m *TagModel) GetAll(filter EventFilter) ([]*Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b queryBuilder
+	b.filterEvents(filter)
+
+	query := `
+		SELECT t.name, COUNT(*)
+		FROM tags t
+		JOIN event_tags et ON et.tag_id = t.id
+		JOIN ` + liveEvents + ` e ON e.id = et.event_id` + b.where() + `
+		GROUP BY t.id
+		ORDER BY COUNT(*) DESC, t.name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.Name, &tag.Events); err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
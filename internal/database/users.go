@@ -6,24 +6,49 @@ import (
 	"time"
 )
 
+const (
+	RoleUser      = "user"
+	RoleOrganizer = "organizer"
+	RoleAdmin     = "admin"
+)
+
+// userColumns lists the columns getUser scans, in order.
+const userColumns = "id, email, name, password, role, verified_at, deletion_scheduled_at"
+
+// liveUsers stands in for the users table in queries that list other
+// users: it leaves out the accounts waiting to be deleted, until their
+// owner logs in again or they are purged.
+const liveUsers = "(SELECT * FROM users WHERE deletion_scheduled_at IS NULL)"
+
 type UserModel struct {
 	DB *sql.DB
 }
 
 type User struct {
-	Id       int    `json:"id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Password string `json:"-"`
+	Id         int        `json:"id"`
+	Email      string     `json:"email"`
+	Name       string     `json:"name"`
+	Password   string     `json:"-"`
+	Role       string     `json:"role,omitempty"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+	// DeletionScheduledAt is set while the account waits out the grace
+	// period after its owner deleted it. It marks the account soft-deleted:
+	// it cannot authenticate, and it and its events are left out of every
+	// listing, until its owner logs in again or it is purged.
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
 }
 
 func (m *UserModel) Insert(user *User) error{
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO users (email, password, name) VALUES ($1, $2, $3) RETURNING id"
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
+	query := "INSERT INTO users (email, password, name, role) VALUES ($1, $2, $3, $4) RETURNING id"
 
-	return m.DB.QueryRowContext(ctx, query, user.Email, user.Password, user.Name).Scan(&user.Id)
+	return m.DB.QueryRowContext(ctx, query, user.Email, user.Password, user.Name, user.Role).Scan(&user.Id)
 }
 
 func (m *UserModel) getUser(query string, args ...interface{})(*User, error){
@@ -33,7 +58,7 @@ func (m *UserModel) getUser(query string, args ...interface{})(*User, error){
 
 	var user User
 
-	err := m.DB.QueryRowContext(ctx,query, args...).Scan(&user.Id, &user.Email, &user.Name, &user.Password)
+	err := m.DB.QueryRowContext(ctx,query, args...).Scan(&user.Id, &user.Email, &user.Name, &user.Password, &user.Role, &user.VerifiedAt, &user.DeletionScheduledAt)
 	if err != nil {
 		if err == sql.ErrNoRows{
 			return nil, nil
@@ -44,14 +69,112 @@ func (m *UserModel) getUser(query string, args ...interface{})(*User, error){
 }
 
 func (m *UserModel) Get(id int)(*User, error) {
-	query := "SELECT * FROM users WHERE id = $1"
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1"
 	return m.getUser(query, id)
 
 }
 
 
 func (m *UserModel) GetByEmail(email string)(*User, error) {
-	query := "SELECT * FROM users WHERE id = $1"
+	query := "SELECT " + userColumns + " FROM users WHERE email = $1"
 	return m.getUser(query, email)
 
-}
\ No newline at end of file
+}
+
+func (m *UserModel) UpdateRole(id int, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET role = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, role, id)
+	return err
+}
+
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET password = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, password, id)
+	return err
+}
+
+func (m *UserModel) MarkVerified(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET verified_at = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id)
+	return err
+}
+
+func (m *UserModel) UpdateName(id int, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET name = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, name, id)
+	return err
+}
+
+// UpdateEmail switches the user to an address they just proved they own, so
+// it is stored as verified.
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET email = $1, verified_at = $2 WHERE id = $3"
+	_, err := m.DB.ExecContext(ctx, query, email, time.Now().UTC(), id)
+	return err
+}
+
+// ScheduleDeletion marks the account for deletion at the given time.
+// Restore cancels it.
+func (m *UserModel) ScheduleDeletion(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, at, id)
+	return err
+}
+
+// Restore cancels the scheduled deletion of an account. Its seats were
+// given to the waitlist meanwhile, so where an occurrence has no seat left
+// the user goes back on the waitlist rather than over capacity.
+func (m *UserModel) Restore(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bulkTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := waitlistRestored(ctx, tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteScheduled removes the accounts whose grace period ended before the
+// given time, together with everything that references them, and returns how
+// many were removed.
+func (m *UserModel) DeleteScheduled(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "DELETE FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $1"
+	result, err := m.DB.ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
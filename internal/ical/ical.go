@@ -0,0 +1,333 @@
+// Package ical reads and writes calendars of events in the iCalendar format
+// of RFC 5545, for calendar apps to import or subscribe to.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// maxLineLength is the number of octets after which content lines are
+// folded.
+const maxLineLength = 75
+
+type Calendar struct {
+	// ProdId identifies the product that wrote the calendar.
+	ProdId string
+	// Name is shown by calendar apps that subscribe to the calendar.
+	Name   string
+	Events []*Event
+}
+
+// Event is a VEVENT. An occurrence of a recurring event that differs from
+// the series is a separate Event with the same UID and a RecurrenceId.
+type Event struct {
+	// UID identifies the event across versions of the calendar.
+	UID string
+	// Sequence is increased whenever the event changes.
+	Sequence int
+	// Stamp is when the event was last changed.
+	Stamp time.Time
+	Start time.Time
+	End   time.Time
+	// TimeZone is the IANA time zone Start and End are written in. They
+	// are written in UTC if it is empty or UTC.
+	TimeZone    string
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Status      string
+	// RRule is the recurrence rule of a recurring event.
+	RRule string
+	// ExDates are the starts of cancelled occurrences of a recurring event.
+	ExDates []time.Time
+	// RecurrenceId is the start the series gives an occurrence this event
+	// overrides.
+	RecurrenceId time.Time
+	// Categories classify the event.
+	Categories []string
+	// Attendees are the email addresses of the attendees.
+	Attendees []string
+	// Line is the line the event begins at, for events read by a Reader.
+	Line int
+}
+
+// WriteTo writes the calendar to w.
+func (c *Calendar) WriteTo(w io.Writer) (int64, error) {
+	var b writer
+
+	b.line("BEGIN", "VCALENDAR")
+	b.line("VERSION", "2.0")
+	b.line("PRODID", c.ProdId)
+	b.line("CALSCALE", "GREGORIAN")
+	if c.Name != "" {
+		b.line("X-WR-CALNAME", escape(c.Name))
+	}
+
+	// Every zone an event refers to needs a VTIMEZONE, described from the
+	// year of the zone's first event on.
+	first := map[string]time.Time{}
+	for _, event := range c.Events {
+		if zoned(event.TimeZone) {
+			if t, ok := first[event.TimeZone]; !ok || event.Start.Before(t) {
+				first[event.TimeZone] = event.Start
+			}
+		}
+	}
+
+	zones := make([]string, 0, len(first))
+	for zone := range first {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+
+	for _, zone := range zones {
+		if err := b.timezone(zone, first[zone]); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, event := range c.Events {
+		b.event(event)
+	}
+
+	b.line("END", "VCALENDAR")
+
+	return b.WriteTo(w)
+}
+
+// writer writes content lines, folded and ended with CRLF.
+type writer struct {
+	bytes.Buffer
+}
+
+func (b *writer) line(name, value string) {
+	line := name + ":" + value
+
+	limit := maxLineLength
+	for len(line) > limit {
+		// Fold between characters, never inside one.
+		n := limit
+		for !utf8.RuneStart(line[n]) {
+			n--
+		}
+		b.WriteString(line[:n])
+		b.WriteString("\r\n ")
+		line = line[n:]
+
+		// The space that starts a continuation line counts towards its
+		// length.
+		limit = maxLineLength - 1
+	}
+
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// timeProperty writes a DATE-TIME property in zone, or in UTC.
+func (b *writer) timeProperty(name string, zone string, times ...time.Time) {
+	values := make([]string, len(times))
+	for i, t := range times {
+		values[i] = formatTime(t, zone)
+	}
+
+	if zoned(zone) {
+		name += ";TZID=" + zone
+	}
+	b.line(name, strings.Join(values, ","))
+}
+
+func (b *writer) event(event *Event) {
+	b.line("BEGIN", "VEVENT")
+	b.line("UID", escape(event.UID))
+	b.line("DTSTAMP", formatTime(event.Stamp, ""))
+	if !event.RecurrenceId.IsZero() {
+		b.timeProperty("RECURRENCE-ID", event.TimeZone, event.RecurrenceId)
+	}
+	b.timeProperty("DTSTART", event.TimeZone, event.Start)
+	b.timeProperty("DTEND", event.TimeZone, event.End)
+	b.line("SEQUENCE", fmt.Sprint(event.Sequence))
+	b.line("SUMMARY", escape(event.Summary))
+	if event.Description != "" {
+		b.line("DESCRIPTION", escape(event.Description))
+	}
+	if event.Location != "" {
+		b.line("LOCATION", escape(event.Location))
+	}
+	if event.URL != "" {
+		b.line("URL", event.URL)
+	}
+	if event.Status != "" {
+		b.line("STATUS", event.Status)
+	}
+	if len(event.Categories) > 0 {
+		categories := make([]string, len(event.Categories))
+		for i, category := range event.Categories {
+			categories[i] = escape(category)
+		}
+		b.line("CATEGORIES", strings.Join(categories, ","))
+	}
+	if event.RRule != "" {
+		b.line("RRULE", event.RRule)
+	}
+	if len(event.ExDates) > 0 {
+		b.timeProperty("EXDATE", event.TimeZone, event.ExDates...)
+	}
+	for _, email := range event.Attendees {
+		b.line("ATTENDEE", "mailto:"+email)
+	}
+	b.line("END", "VEVENT")
+}
+
+// timezone writes a VTIMEZONE for an IANA zone. Zones that switch between
+// standard and daylight time twice a year are described by yearly rules
+// taken from the switches in the year of from; zones that do not switch
+// that year by the offset they had then.
+func (b *writer) timezone(zone string, from time.Time) error {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return err
+	}
+
+	year := from.In(loc).Year()
+	transitions := yearTransitions(loc, year)
+
+	b.line("BEGIN", "VTIMEZONE")
+	b.line("TZID", zone)
+
+	if len(transitions) == 2 {
+		for _, t := range transitions {
+			b.observance(loc, t, true)
+		}
+	} else if len(transitions) == 0 {
+		start := time.Date(year, 1, 1, 0, 0, 0, 0, loc)
+		name, offset := start.Zone()
+		b.line("BEGIN", "STANDARD")
+		b.line("DTSTART", start.Format("20060102T150405"))
+		b.line("TZOFFSETFROM", formatOffset(offset))
+		b.line("TZOFFSETTO", formatOffset(offset))
+		b.line("TZNAME", escape(name))
+		b.line("END", "STANDARD")
+	} else {
+		for _, t := range transitions {
+			b.observance(loc, t, false)
+		}
+	}
+
+	b.line("END", "VTIMEZONE")
+	return nil
+}
+
+// observance writes the STANDARD or DAYLIGHT component that starts at the
+// transition t, repeating yearly on the same weekday of the month if
+// yearly is set.
+func (b *writer) observance(loc *time.Location, t time.Time, yearly bool) {
+	after := t.In(loc)
+	_, before := t.Add(-time.Second).In(loc).Zone()
+	name, offset := after.Zone()
+
+	kind := "STANDARD"
+	if after.IsDST() {
+		kind = "DAYLIGHT"
+	}
+
+	// DTSTART is the wall-clock time the transition happens at, in the
+	// offset that was in effect until then.
+	start := t.UTC().Add(time.Duration(before) * time.Second)
+
+	b.line("BEGIN", kind)
+	b.line("DTSTART", start.Format("20060102T150405"))
+	if yearly {
+		b.line("RRULE", fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%s", int(start.Month()), weekdayOfMonth(start)))
+	}
+	b.line("TZOFFSETFROM", formatOffset(before))
+	b.line("TZOFFSETTO", formatOffset(offset))
+	b.line("TZNAME", escape(name))
+	b.line("END", kind)
+}
+
+// yearTransitions returns the instants in the year at which loc changes its
+// offset from UTC.
+func yearTransitions(loc *time.Location, year int) []time.Time {
+	var transitions []time.Time
+
+	end := time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	for t := prev.Add(24 * time.Hour); !t.After(end); t = t.Add(24 * time.Hour) {
+		if offset(prev, loc) != offset(t, loc) {
+			// Narrow the day down to the second the offset changes at.
+			lo, hi := prev, t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Second)
+				if offset(mid, loc) == offset(lo, loc) {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			transitions = append(transitions, hi)
+		}
+		prev = t
+	}
+
+	return transitions
+}
+
+func offset(t time.Time, loc *time.Location) int {
+	_, offset := t.In(loc).Zone()
+	return offset
+}
+
+// weekdayOfMonth returns the BYDAY value that picks t's weekday of the
+// month: 2SU for the second Sunday, -1SU for the last one.
+func weekdayOfMonth(t time.Time) string {
+	day := strings.ToUpper(t.Weekday().String()[:2])
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if t.Day()+7 > daysInMonth {
+		return "-1" + day
+	}
+	return fmt.Sprintf("%d%s", (t.Day()-1)/7+1, day)
+}
+
+func zoned(zone string) bool {
+	return zone != "" && zone != "UTC"
+}
+
+// formatTime formats t as local time in zone, or in UTC.
+func formatTime(t time.Time, zone string) string {
+	if zoned(zone) {
+		if loc, err := time.LoadLocation(zone); err == nil {
+			return t.In(loc).Format("20060102T150405")
+		}
+	}
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
+
+// escape escapes a TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", "").Replace(s)
+}
@@ -0,0 +1,413 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseError is an error in the content line or VEVENT starting at Line.
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads the VEVENTs of a calendar one at a time. Other components,
+// VTIMEZONE included, are skipped: TZID parameters must name IANA time
+// zones.
+type Reader struct {
+	s *bufio.Scanner
+	// line counts the physical lines read so far.
+	line int
+
+	// next is the physical line after the content line being unfolded.
+	next     string
+	nextLine int
+	hasNext  bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	return &Reader{s: s}
+}
+
+// Read returns the next VEVENT, or io.EOF when there are no more. A VEVENT
+// that cannot be read is skipped and returned as a *ParseError, after
+// which reading can go on.
+func (r *Reader) Read() (*Event, error) {
+	for {
+		line, n, err := r.contentLine()
+		if err != nil {
+			return nil, err
+		}
+
+		prop, err := parseProperty(line)
+		if err != nil {
+			return nil, &ParseError{Line: n, Err: err}
+		}
+
+		if prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VEVENT") {
+			return r.readEvent(n)
+		}
+	}
+}
+
+// readEvent reads the properties of the VEVENT that begins at line start,
+// up to its END.
+func (r *Reader) readEvent(start int) (*Event, error) {
+	event := &Event{Line: start}
+
+	var (
+		first    error
+		depth    int
+		end      *property
+		duration *property
+		allDay   bool
+	)
+
+	fail := func(n int, err error) {
+		if first == nil {
+			first = &ParseError{Line: n, Err: err}
+		}
+	}
+
+	for {
+		line, n, err := r.contentLine()
+		if err == io.EOF {
+			return nil, &ParseError{Line: start, Err: errors.New("VEVENT is not ended")}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		prop, err := parseProperty(line)
+		if err != nil {
+			fail(n, err)
+			continue
+		}
+
+		// Components nested in the event, such as VALARM, are skipped.
+		if prop.Name == "BEGIN" {
+			depth++
+			continue
+		}
+		if prop.Name == "END" {
+			if depth == 0 {
+				if !strings.EqualFold(prop.Value, "VEVENT") {
+					return nil, &ParseError{Line: start, Err: errors.New("VEVENT is not ended")}
+				}
+				break
+			}
+			depth--
+			continue
+		}
+		if depth > 0 {
+			continue
+		}
+
+		switch prop.Name {
+		case "UID":
+			event.UID = unescape(prop.Value)
+		case "SEQUENCE":
+			if event.Sequence, err = strconv.Atoi(prop.Value); err != nil {
+				fail(n, fmt.Errorf("SEQUENCE must be a number"))
+			}
+		case "DTSTART":
+			times, zone, date, err := prop.times()
+			if err != nil {
+				fail(n, err)
+				continue
+			}
+			event.Start, event.TimeZone, allDay = times[0], zone, date
+		case "DTEND":
+			end = prop
+		case "DURATION":
+			duration = prop
+		case "SUMMARY":
+			event.Summary = unescape(prop.Value)
+		case "DESCRIPTION":
+			event.Description = unescape(prop.Value)
+		case "LOCATION":
+			event.Location = unescape(prop.Value)
+		case "URL":
+			event.URL = prop.Value
+		case "STATUS":
+			event.Status = strings.ToUpper(prop.Value)
+		case "RRULE":
+			event.RRule = prop.Value
+		case "EXDATE":
+			times, _, _, err := prop.times()
+			if err != nil {
+				fail(n, err)
+				continue
+			}
+			event.ExDates = append(event.ExDates, times...)
+		case "RECURRENCE-ID":
+			times, _, _, err := prop.times()
+			if err != nil {
+				fail(n, err)
+				continue
+			}
+			event.RecurrenceId = times[0]
+		case "CATEGORIES":
+			for _, category := range splitText(prop.Value) {
+				event.Categories = append(event.Categories, unescape(category))
+			}
+		case "ATTENDEE":
+			if email, ok := cutPrefixFold(prop.Value, "mailto:"); ok {
+				event.Attendees = append(event.Attendees, email)
+			}
+		}
+	}
+
+	if first != nil {
+		return nil, first
+	}
+
+	if event.Start.IsZero() {
+		return nil, &ParseError{Line: start, Err: errors.New("DTSTART is missing")}
+	}
+
+	// Without DTEND or DURATION, an event on a date lasts the day and one
+	// at a time takes no time.
+	switch {
+	case end != nil:
+		times, _, _, err := end.times()
+		if err != nil {
+			return nil, &ParseError{Line: start, Err: err}
+		}
+		event.End = times[0]
+	case duration != nil:
+		days, d, err := parseDuration(duration.Value)
+		if err != nil {
+			return nil, &ParseError{Line: start, Err: err}
+		}
+		event.End = event.Start.In(zoneOf(event.TimeZone)).AddDate(0, 0, days).Add(d)
+	case allDay:
+		event.End = event.Start.In(zoneOf(event.TimeZone)).AddDate(0, 0, 1)
+	default:
+		event.End = event.Start
+	}
+
+	return event, nil
+}
+
+// contentLine returns the next content line, unfolded, and the number of
+// the physical line it starts at. Blank lines are skipped.
+func (r *Reader) contentLine() (string, int, error) {
+	for {
+		if !r.hasNext && !r.scan() {
+			if err := r.s.Err(); err != nil {
+				return "", 0, err
+			}
+			return "", 0, io.EOF
+		}
+
+		line, n := r.next, r.nextLine
+		r.hasNext = false
+
+		for r.scan() {
+			if !strings.HasPrefix(r.next, " ") && !strings.HasPrefix(r.next, "\t") {
+				break
+			}
+			line += r.next[1:]
+			r.hasNext = false
+		}
+
+		if line != "" {
+			return line, n, nil
+		}
+	}
+}
+
+// scan reads the next physical line into next.
+func (r *Reader) scan() bool {
+	if !r.s.Scan() {
+		return false
+	}
+	r.line++
+	r.next = strings.TrimSuffix(r.s.Text(), "\r")
+	r.nextLine = r.line
+	r.hasNext = true
+	return true
+}
+
+// property is a content line: a name, its parameters and a value.
+type property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+func parseProperty(line string) (*property, error) {
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, errors.New("content line has no name")
+	}
+
+	p := &property{Name: strings.ToUpper(line[:i]), Params: map[string]string{}}
+	rest := line[i:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("%s has a parameter without a value", p.Name)
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%s has an unterminated quoted parameter", p.Name)
+			}
+			p.Params[key] = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return nil, fmt.Errorf("%s has no value", p.Name)
+			}
+			p.Params[key] = rest[:end]
+			rest = rest[end:]
+		}
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return nil, fmt.Errorf("%s has no value", p.Name)
+	}
+	p.Value = rest[1:]
+
+	return p, nil
+}
+
+// times parses the DATE or DATE-TIME values of the property. It returns
+// the TZID they are in, empty for UTC and floating times, which are read as
+// UTC, and whether they are dates.
+func (p *property) times() ([]time.Time, string, bool, error) {
+	loc, zone := time.UTC, p.Params["TZID"]
+	if zone != "" {
+		var err error
+		loc, err = time.LoadLocation(zone)
+		if err != nil || zone == "Local" {
+			return nil, "", false, fmt.Errorf("%s has unknown time zone %q", p.Name, zone)
+		}
+	}
+
+	date := strings.EqualFold(p.Params["VALUE"], "DATE")
+
+	var times []time.Time
+	for _, value := range strings.Split(p.Value, ",") {
+		var (
+			t   time.Time
+			err error
+		)
+		switch {
+		case date || len(value) == 8:
+			date = true
+			t, err = time.ParseInLocation("20060102", value, loc)
+		case strings.HasSuffix(value, "Z"):
+			t, err = time.Parse("20060102T150405Z", value)
+		default:
+			t, err = time.ParseInLocation("20060102T150405", value, loc)
+		}
+		if err != nil {
+			return nil, "", false, fmt.Errorf("%s has invalid time %q", p.Name, value)
+		}
+		times = append(times, t)
+	}
+
+	return times, zone, date, nil
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W|(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?)$`)
+
+// parseDuration parses a DURATION value into days, which are added on the
+// calendar, and the time on top of them.
+func parseDuration(value string) (int, time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(strings.ToUpper(value))
+	if m == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, 0, fmt.Errorf("DURATION has invalid value %q", value)
+	}
+
+	n := func(s string) int {
+		v, _ := strconv.Atoi(s)
+		return v
+	}
+
+	days := n(m[2])*7 + n(m[3])
+	d := time.Duration(n(m[4]))*time.Hour + time.Duration(n(m[5]))*time.Minute + time.Duration(n(m[6]))*time.Second
+	if m[1] == "-" {
+		days, d = -days, -d
+	}
+	return days, d, nil
+}
+
+func zoneOf(zone string) *time.Location {
+	if loc, err := time.LoadLocation(zone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// splitText splits a list of TEXT values at the commas that are not
+// escaped.
+func splitText(s string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			values = append(values, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(values, s[start:])
+}
+
+// unescape reverses escape.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,149 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single SMTP session on a local port and records
+// what the client sent. It offers neither STARTTLS nor AUTH.
+type fakeSMTPServer struct {
+	listener net.Listener
+	done     chan struct{}
+
+	from string
+	to   []string
+	data string
+	err  error
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTPServer{listener: listener, done: make(chan struct{})}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost fake ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			s.from = line
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			s.to = append(s.to, line)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.data = strings.Join(lines, "\n")
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPMailerSendsResetMail(t *testing.T) {
+	server := startFakeSMTPServer(t)
+
+	m := &SMTPMailer{Host: "127.0.0.1", Port: server.port(), From: "Events API <noreply@example.com>"}
+	msg := &Message{
+		To:      "Alice <alice@example.com>",
+		Subject: "Reset your password",
+		Body:    "Hi Alice,\n\nOpen the link below to choose a new one:\n\nhttp://localhost:8080/reset?token=abc\n.\nThe link expires in 60 minutes.\n",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := m.Send(ctx, msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-server.done
+	if server.err != nil {
+		t.Fatalf("server: %v", server.err)
+	}
+
+	// net/smtp declares the 8-bit body the server offers to accept.
+	if want := "MAIL FROM:<noreply@example.com> BODY=8BITMIME"; server.from != want {
+		t.Errorf("MAIL = %q, want %q", server.from, want)
+	}
+	if len(server.to) != 1 || server.to[0] != "RCPT TO:<alice@example.com>" {
+		t.Errorf("RCPT = %q, want only <alice@example.com>", server.to)
+	}
+
+	headers, body, ok := strings.Cut(server.data, "\n\n")
+	if !ok {
+		t.Fatalf("message has no body: %q", server.data)
+	}
+
+	h, err := textproto.NewReader(bufio.NewReader(strings.NewReader(headers + "\n\n"))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("reading headers: %v", err)
+	}
+	for key, want := range map[string]string{
+		"From":         "Events API <noreply@example.com>",
+		"To":           "Alice <alice@example.com>",
+		"Subject":      "Reset your password",
+		"Content-Type": "text/plain; charset=utf-8",
+	} {
+		if got := h.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if h.Get("Message-Id") == "" || h.Get("Date") == "" {
+		t.Errorf("Message-ID and Date must be set, got %v", h)
+	}
+
+	// The lone dot is escaped on the wire and must arrive intact.
+	if body != msg.Body {
+		t.Errorf("body = %q, want %q", body, msg.Body)
+	}
+}
@@ -0,0 +1,390 @@
+// Package rrule implements the subset of RFC 5545 recurrence rules that events
+// use: DAILY, WEEKLY and MONTHLY frequencies with INTERVAL, COUNT, UNTIL and
+// BYDAY. Occurrences are whole days; times of day are ignored.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+const (
+	maxInterval = 1000
+	maxCount    = 10000
+)
+
+var dayNames = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Weekday is a BYDAY entry. N selects the Nth such weekday of the month,
+// counting from the end if negative; 0 selects all of them.
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+func (w Weekday) String() string {
+	name := strings.ToUpper(w.Day.String()[:2])
+	if w.N != 0 {
+		return strconv.Itoa(w.N) + name
+	}
+	return name
+}
+
+// Rule is a parsed recurrence rule. The series it describes starts on a date
+// given separately, like DTSTART in iCalendar.
+type Rule struct {
+	Freq     string
+	Interval int
+	// Count limits the number of occurrences, including the first. 0 means
+	// no limit.
+	Count int
+	// Until is the last day an occurrence may fall on. The zero time means
+	// no limit.
+	Until time.Time
+	ByDay []Weekday
+}
+
+// Parse parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10".
+// An "RRULE:" prefix is allowed. Rule parts outside the supported subset are
+// rejected rather than ignored, so a rule never means less than it says. A
+// UTC UNTIL time is taken as its date in UTC; see ParseIn.
+func Parse(s string) (*Rule, error) {
+	return ParseIn(s, time.UTC)
+}
+
+// ParseIn parses a rule like Parse for a series taking place in loc: an
+// UNTIL given as a UTC time becomes the date it falls on in loc.
+func ParseIn(s string, loc *time.Location) (*Rule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	if s == "" {
+		return nil, errors.New("rrule: empty rule")
+	}
+
+	rule := &Rule{Interval: 1}
+	seen := map[string]bool{}
+
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("rrule: invalid part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("rrule: %s is given twice", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			if value != Daily && value != Weekly && value != Monthly {
+				return nil, fmt.Errorf("rrule: unsupported frequency %s, use DAILY, WEEKLY or MONTHLY", value)
+			}
+			rule.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxInterval {
+				return nil, fmt.Errorf("rrule: INTERVAL must be between 1 and %d", maxInterval)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxCount {
+				return nil, fmt.Errorf("rrule: COUNT must be between 1 and %d", maxCount)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseUntil(value, loc)
+			if err != nil {
+				return nil, err
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, err := parseWeekday(day)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		default:
+			return nil, fmt.Errorf("rrule: unsupported rule part %s", name)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, errors.New("rrule: FREQ is required")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, errors.New("rrule: COUNT and UNTIL cannot be combined")
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly {
+			return nil, fmt.Errorf("rrule: %s needs FREQ=MONTHLY", day)
+		}
+	}
+
+	return rule, nil
+}
+
+// parseUntil parses an UNTIL value: a date, a local time, whose date is
+// taken, or a UTC time, which is converted to loc first.
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return day(t.In(loc)), nil
+	}
+	if t, err := time.Parse("20060102T150405", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("rrule: invalid UNTIL %s, use YYYYMMDD", value)
+}
+
+func parseWeekday(value string) (Weekday, error) {
+	if len(value) < 2 {
+		return Weekday{}, fmt.Errorf("rrule: invalid BYDAY %q", value)
+	}
+
+	day, ok := dayNames[value[len(value)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("rrule: invalid BYDAY %q", value)
+	}
+
+	weekday := Weekday{Day: day}
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Weekday{}, fmt.Errorf("rrule: invalid BYDAY %q", value)
+		}
+		weekday.N = n
+	}
+
+	return weekday, nil
+}
+
+// String returns the rule in canonical form.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// ICalendar returns the rule as an iCalendar RRULE value for a series
+// whose DTSTART is a time in loc. RFC 5545 wants UNTIL to be of the same
+// type as DTSTART, so it is written as the end of its day in loc, in UTC.
+func (r *Rule) ICalendar(loc *time.Location) string {
+	if r.Until.IsZero() {
+		return r.String()
+	}
+
+	rule := *r
+	rule.Until = time.Time{}
+	end := time.Date(r.Until.Year(), r.Until.Month(), r.Until.Day(), 23, 59, 59, 0, loc)
+	return rule.String() + ";UNTIL=" + end.UTC().Format("20060102T150405Z")
+}
+
+// Between returns the occurrences of the series starting on start that fall
+// within from and to, inclusive, in order.
+func (r *Rule) Between(start, from, to time.Time) []time.Time {
+	from, to = day(from), day(to)
+
+	var occurrences []time.Time
+	r.each(start, to, func(t time.Time) {
+		if !t.Before(from) {
+			occurrences = append(occurrences, t)
+		}
+	})
+	return occurrences
+}
+
+// Occurs reports whether the series starting on start has an occurrence on
+// date.
+func (r *Rule) Occurs(start, date time.Time) bool {
+	date = day(date)
+	found := false
+	r.each(start, date, func(t time.Time) {
+		found = found || t.Equal(date)
+	})
+	return found
+}
+
+// Last returns the last occurrence of the series starting on start, or
+// false if the rule has neither COUNT nor UNTIL and so never ends.
+func (r *Rule) Last(start time.Time) (time.Time, bool) {
+	if r.Count == 0 && r.Until.IsZero() {
+		return time.Time{}, false
+	}
+
+	// COUNT ends the series within maxCount occurrences and UNTIL at its
+	// date, so end only matters as a bound.
+	end := r.Until
+	if end.IsZero() {
+		end = day(start).AddDate(maxCount*maxInterval, 0, 0)
+	}
+
+	var last time.Time
+	found := false
+	r.each(start, end, func(t time.Time) {
+		last, found = t, true
+	})
+	return last, found
+}
+
+// each calls fn with every occurrence of the series starting on start, in
+// order, until the occurrences run out or pass end.
+func (r *Rule) each(start, end time.Time, fn func(time.Time)) {
+	start, end = day(start), day(end)
+	if !r.Until.IsZero() && r.Until.Before(end) {
+		end = r.Until
+	}
+
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	count := 0
+	for period := 0; ; period++ {
+		periodStart, candidates := r.period(start, period*interval)
+		if periodStart.After(end) {
+			return
+		}
+
+		for _, t := range candidates {
+			if t.Before(start) {
+				continue
+			}
+			if t.After(end) {
+				return
+			}
+
+			fn(t)
+
+			count++
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// period returns the first day of the period offset periods of the rule's
+// frequency after the one start falls into, and the days in it that match
+// the rule, in order.
+func (r *Rule) period(start time.Time, offset int) (time.Time, []time.Time) {
+	switch r.Freq {
+	case Weekly:
+		// Weeks start on Monday, the iCalendar default.
+		monday := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		weekStart := monday.AddDate(0, 0, 7*offset)
+
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []Weekday{{Day: start.Weekday()}}
+		}
+
+		var candidates []time.Time
+		for _, d := range days {
+			candidates = append(candidates, weekStart.AddDate(0, 0, (int(d.Day)+6)%7))
+		}
+		return weekStart, sortDays(candidates)
+
+	case Monthly:
+		monthStart := time.Date(start.Year(), start.Month()+time.Month(offset), 1, 0, 0, 0, 0, time.UTC)
+		daysInMonth := monthStart.AddDate(0, 1, -1).Day()
+
+		if len(r.ByDay) == 0 {
+			if start.Day() > daysInMonth {
+				return monthStart, nil
+			}
+			return monthStart, []time.Time{monthStart.AddDate(0, 0, start.Day()-1)}
+		}
+
+		var candidates []time.Time
+		for _, d := range r.ByDay {
+			first := monthStart.AddDate(0, 0, (int(d.Day)-int(monthStart.Weekday())+7)%7)
+			var matches []time.Time
+			for t := first; t.Month() == monthStart.Month(); t = t.AddDate(0, 0, 7) {
+				matches = append(matches, t)
+			}
+
+			switch {
+			case d.N == 0:
+				candidates = append(candidates, matches...)
+			case d.N > 0 && d.N <= len(matches):
+				candidates = append(candidates, matches[d.N-1])
+			case d.N < 0 && -d.N <= len(matches):
+				candidates = append(candidates, matches[len(matches)+d.N])
+			}
+		}
+		return monthStart, sortDays(candidates)
+
+	default:
+		t := start.AddDate(0, 0, offset)
+		if len(r.ByDay) > 0 && !r.onDay(t) {
+			return t, nil
+		}
+		return t, []time.Time{t}
+	}
+}
+
+func (r *Rule) onDay(t time.Time) bool {
+	for _, d := range r.ByDay {
+		if d.Day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// sortDays sorts days and removes duplicates.
+func sortDays(days []time.Time) []time.Time {
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	unique := days[:0]
+	for i, t := range days {
+		if i == 0 || !t.Equal(days[i-1]) {
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// day truncates t to midnight UTC of its calendar day.
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}